@@ -0,0 +1,314 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/toluhikay/go-react/internal/mailer"
+	"github.com/toluhikay/go-react/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	passwordHashCost        = 12
+	minPasswordLength       = 8
+	emailVerificationExpiry = time.Hour * 24
+	passwordResetExpiry     = time.Hour
+	inviteExpiry            = time.Hour * 72
+)
+
+// hash a password the same way User.PasswordMatch expects to check it
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordHashCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func validatePassword(password string) error {
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters long", minPasswordLength)
+	}
+	return nil
+}
+
+func validateEmail(email string) error {
+	at := strings.Index(email, "@")
+	if at < 1 || at == len(email)-1 || strings.ContainsAny(email, " \t\r\n") {
+		return errors.New("a valid email address is required")
+	}
+	return nil
+}
+
+func (app *application) register(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+		Email     string `json:"email"`
+		Password  string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload.Email = strings.ToLower(strings.TrimSpace(payload.Email))
+	if strings.TrimSpace(payload.FirstName) == "" || strings.TrimSpace(payload.LastName) == "" {
+		app.errorJSON(w, errors.New("first and last name are required"))
+		return
+	}
+	if err := validateEmail(payload.Email); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	if err := validatePassword(payload.Password); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// make sure the email is not taken
+	_, err = app.DB.GetUserByEMail(payload.Email)
+	if err == nil {
+		app.errorJSON(w, errors.New("an account with that email already exists"), http.StatusConflict)
+		return
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	hash, err := hashPassword(payload.Password)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	user := models.User{
+		FirstName:     strings.TrimSpace(payload.FirstName),
+		LastName:      strings.TrimSpace(payload.LastName),
+		Email:         payload.Email,
+		Password:      hash,
+		EmailVerified: false,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+
+	user.ID, err = app.DB.InsertUser(user, nil)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.sendVerificationEmail(&user)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "account created, check your email to verify your address",
+	}
+
+	_ = app.writeJSON(w, http.StatusCreated, resp)
+}
+
+// send the signed link a user follows to verify their email address
+func (app *application) sendVerificationEmail(user *models.User) error {
+	token, err := app.auth.GeneratePurposeToken(purposeEmailVerification, user.ID, user.Email, emailVerificationExpiry)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/verify-email?token=%s", app.BaseURL, url.QueryEscape(token))
+
+	return app.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nFollow the link below to verify your email address. It expires in %s.\n\n%s\n",
+			user.FirstName, emailVerificationExpiry, link),
+	})
+}
+
+// tell the old address that the account's email was changed, so the owner
+// hears about it if it wasn't them
+func (app *application) sendEmailChangedNotice(user *models.User, oldEmail string) error {
+	return app.mailer.Send(mailer.Message{
+		To:      oldEmail,
+		Subject: "Your email address was changed",
+		Body: fmt.Sprintf("Hi %s,\n\nThe email address on your account was changed to %s. If you didn't do this, contact support straight away.\n",
+			user.FirstName, user.Email),
+	})
+}
+
+func (app *application) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		app.errorJSON(w, errors.New("missing verification token"))
+		return
+	}
+
+	userID, email, err := app.auth.ParsePurposeToken(purposeEmailVerification, token)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid or expired verification link"))
+		return
+	}
+
+	err = app.DB.VerifyUserEmail(userID, email)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid or expired verification link"))
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "email address verified",
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, resp)
+}
+
+func (app *application) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// always answer the same way so this can't be used to find out who has an account
+	resp := JSONResponse{
+		Error:   false,
+		Message: "if an account exists for that email, a reset link has been sent",
+	}
+
+	user, err := app.DB.GetUserByEMail(strings.ToLower(strings.TrimSpace(payload.Email)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			_ = app.writeJSON(w, http.StatusAccepted, resp)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.sendPasswordResetEmail(user, false)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// send a single use link to choose a new password. Invites go to accounts an
+// admin created and last longer than a normal reset link
+func (app *application) sendPasswordResetEmail(user *models.User, invite bool) error {
+	token, err := generateRandomToken()
+	if err != nil {
+		return err
+	}
+
+	expiry := passwordResetExpiry
+	if invite {
+		expiry = inviteExpiry
+	}
+
+	// only the hash is stored, the plain token goes out in the email
+	err = app.DB.InsertPasswordReset(user.ID, hashToken(token), time.Now().Add(expiry))
+	if err != nil {
+		return err
+	}
+
+	// the link opens the frontend's reset form, which posts the token and the
+	// new password to /password/reset
+	link, err := withQuery(app.PasswordResetURL, "token", token)
+	if err != nil {
+		return err
+	}
+
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nFollow the link below to choose a new password. It expires in %s and can only be used once.\n\n%s\n\nIf you didn't ask for this you can ignore this email.\n",
+			user.FirstName, expiry, link),
+	}
+	if invite {
+		msg.Subject = "You have been invited"
+		msg.Body = fmt.Sprintf("Hi %s,\n\nAn account has been created for you. Follow the link below to choose your password. It expires in %s and can only be used once.\n\n%s\n",
+			user.FirstName, expiry, link)
+	}
+
+	return app.mailer.Send(msg)
+}
+
+// add a query parameter to a url that may already have some
+func withQuery(rawURL, key, value string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set(key, value)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+func (app *application) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if payload.Token == "" {
+		app.errorJSON(w, errors.New("missing reset token"))
+		return
+	}
+	if err := validatePassword(payload.Password); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	hash, err := hashPassword(payload.Password)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_, err = app.DB.ResetPassword(hashToken(payload.Token), hash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("invalid or expired reset token"))
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// the old sessions are gone so drop the refresh cookie on this device too
+	http.SetCookie(w, app.auth.GetExpiredRefreshCookie())
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "password has been reset, please log in again",
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, resp)
+}
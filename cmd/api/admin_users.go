@@ -0,0 +1,438 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/toluhikay/go-react/internal/models"
+)
+
+const (
+	defaultUsersPageSize = 20
+	maxUsersPageSize     = 100
+)
+
+// read a positive integer query parameter, falling back to def when it is missing
+func queryInt(r *http.Request, key string, def int) (int, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, errors.New(key + " must be a positive integer")
+	}
+	return n, nil
+}
+
+// load the user named in the url along with the roles they hold, writing a
+// 404 when there is no such user
+func (app *application) userFromURL(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return nil, false
+	}
+
+	user, err := app.userWithRoles(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("user not found"), http.StatusNotFound)
+			return nil, false
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// load a user and fill in the names of the roles they hold
+func (app *application) userWithRoles(id int) (*models.User, error) {
+	user, err := app.DB.GetUSerById(id)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := app.DB.GetUserRoles(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Roles = []string{}
+	for _, role := range roles {
+		user.Roles = append(user.Roles, role.Role)
+	}
+
+	return user, nil
+}
+
+// refuse to let admins lock themselves out of the admin api
+func (app *application) notSelf(w http.ResponseWriter, r *http.Request, user *models.User) bool {
+	principal, ok := app.principal(w, r)
+	if !ok {
+		return false
+	}
+
+	if principal.UserID == user.ID {
+		app.errorJSON(w, errors.New("you can't do that to your own account"), http.StatusConflict)
+		return false
+	}
+
+	return true
+}
+
+// make sure an email address is free, or only used by the given user
+func (app *application) emailAvailable(w http.ResponseWriter, email string, userID int) bool {
+	existing, err := app.DB.GetUserByEMail(email)
+	if err == nil && existing.ID != userID {
+		app.errorJSON(w, errors.New("an account with that email already exists"), http.StatusConflict)
+		return false
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// make sure every role name exists, checked before the user is written so a
+// typo doesn't leave a half made change behind
+func (app *application) rolesExist(w http.ResponseWriter, roles []string) bool {
+	if len(roles) == 0 {
+		return true
+	}
+
+	unknown, err := app.DB.UnknownRoles(roles)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return false
+	}
+	if len(unknown) > 0 {
+		app.errorJSON(w, fmt.Errorf("unknown role %q", unknown[0]))
+		return false
+	}
+	return true
+}
+
+func (app *application) allUsers(w http.ResponseWriter, r *http.Request) {
+	page, err := queryInt(r, "page", 1)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	pageSize, err := queryInt(r, "page_size", defaultUsersPageSize)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	if pageSize > maxUsersPageSize {
+		pageSize = maxUsersPageSize
+	}
+
+	users, total, err := app.DB.AllUsers(page, pageSize)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	var payload = struct {
+		Users    []*models.User `json:"users"`
+		Metadata struct {
+			Page       int `json:"page"`
+			PageSize   int `json:"page_size"`
+			TotalPages int `json:"total_pages"`
+			Total      int `json:"total"`
+		} `json:"metadata"`
+	}{
+		Users: users,
+	}
+	payload.Metadata.Page = page
+	payload.Metadata.PageSize = pageSize
+	payload.Metadata.Total = total
+	payload.Metadata.TotalPages = (total + pageSize - 1) / pageSize
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+func (app *application) getUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, user)
+}
+
+// create a user on someone's behalf. They get an invite to choose their own
+// password and can't log in until they have
+func (app *application) createUser(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		FirstName string   `json:"first_name"`
+		LastName  string   `json:"last_name"`
+		Email     string   `json:"email"`
+		Roles     []string `json:"roles"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload.Email = strings.ToLower(strings.TrimSpace(payload.Email))
+	if strings.TrimSpace(payload.FirstName) == "" || strings.TrimSpace(payload.LastName) == "" {
+		app.errorJSON(w, errors.New("first and last name are required"))
+		return
+	}
+	if err := validateEmail(payload.Email); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	if !app.emailAvailable(w, payload.Email, 0) {
+		return
+	}
+	if !app.rolesExist(w, payload.Roles) {
+		return
+	}
+
+	// nobody knows this password, it only exists so the column isn't empty
+	placeholder, err := generateRandomToken()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	hash, err := hashPassword(placeholder)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	user := models.User{
+		FirstName:             strings.TrimSpace(payload.FirstName),
+		LastName:              strings.TrimSpace(payload.LastName),
+		Email:                 payload.Email,
+		Password:              hash,
+		EmailVerified:         true,
+		PasswordResetRequired: true,
+		CreatedAt:             time.Now(),
+		UpdatedAt:             time.Now(),
+	}
+
+	// the user and their roles are written together, so a failure can't
+	// leave an account behind with the default role
+	user.ID, err = app.DB.InsertUser(user, payload.Roles)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	user.Roles = []string{models.RoleViewer}
+	if payload.Roles != nil {
+		user.Roles = payload.Roles
+	}
+
+	app.audit(r, auditEvent{Action: auditUserCreate, Entity: "user", EntityID: user.ID, After: user})
+
+	err = app.sendPasswordResetEmail(&user, true)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusCreated, user)
+}
+
+func (app *application) updateUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+	before := *user
+
+	// only the fields that were sent are changed
+	var payload struct {
+		FirstName *string   `json:"first_name"`
+		LastName  *string   `json:"last_name"`
+		Email     *string   `json:"email"`
+		Roles     *[]string `json:"roles"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if payload.FirstName != nil {
+		user.FirstName = strings.TrimSpace(*payload.FirstName)
+	}
+	if payload.LastName != nil {
+		user.LastName = strings.TrimSpace(*payload.LastName)
+	}
+	if user.FirstName == "" || user.LastName == "" {
+		app.errorJSON(w, errors.New("first and last name are required"))
+		return
+	}
+
+	emailChanged := false
+	if payload.Email != nil {
+		email := strings.ToLower(strings.TrimSpace(*payload.Email))
+		if err := validateEmail(email); err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+		if !app.emailAvailable(w, email, user.ID) {
+			return
+		}
+		if email != user.Email {
+			user.Email = email
+			user.EmailVerified = false
+			emailChanged = true
+		}
+	}
+
+	if payload.Roles != nil && !app.rolesExist(w, *payload.Roles) {
+		return
+	}
+
+	var roles []string
+	if payload.Roles != nil {
+		// an empty list takes every role away, which nil would not
+		roles = append([]string{}, *payload.Roles...)
+	}
+
+	err = app.DB.UpdateUser(*user, roles)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	if roles != nil {
+		user.Roles = roles
+	}
+
+	app.audit(r, auditEvent{Action: auditUserUpdate, Entity: "user", EntityID: user.ID, Before: before, After: user})
+
+	// a new address has to be verified before the user can log in with it
+	if emailChanged {
+		err = app.sendVerificationEmail(user)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, user)
+}
+
+func (app *application) disableUser(w http.ResponseWriter, r *http.Request) {
+	app.setUserDisabled(w, r, true)
+}
+
+func (app *application) enableUser(w http.ResponseWriter, r *http.Request) {
+	app.setUserDisabled(w, r, false)
+}
+
+func (app *application) setUserDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if disabled && !app.notSelf(w, r, user) {
+		return
+	}
+
+	err := app.DB.SetUserDisabled(user.ID, disabled)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	action := auditUserEnable
+	if disabled {
+		action = auditUserDisable
+	}
+	app.audit(r, auditEvent{Action: action, Entity: "user", EntityID: user.ID})
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "account enabled",
+	}
+	if disabled {
+		resp.Message = "account disabled"
+	}
+
+	_ = app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// sign the user out everywhere and send them a link to choose a new password
+func (app *application) forcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.DB.RequirePasswordReset(user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, auditEvent{Action: auditUserPasswordReset, Entity: "user", EntityID: user.ID})
+
+	err = app.sendPasswordResetEmail(user, false)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "password reset required, a reset link has been sent",
+	}
+
+	_ = app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+func (app *application) deleteUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.notSelf(w, r, user) {
+		return
+	}
+
+	err := app.DB.DeleteUser(user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, auditEvent{Action: auditUserDelete, Entity: "user", EntityID: user.ID, Before: user})
+
+	// don't leave lockout state behind for an address that may be reused
+	err = app.accountLimiter.Reset(accountKey(user.Email))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "user deleted",
+	}
+
+	_ = app.writeJSON(w, http.StatusAccepted, resp)
+}
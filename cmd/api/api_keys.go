@@ -0,0 +1,199 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/toluhikay/go-react/internal/models"
+)
+
+const (
+	apiKeyPrefix = "mk_"
+	// the part of a key shown in listings so users can tell their keys apart
+	apiKeyDisplayLength = len(apiKeyPrefix) + 8
+	tokenTypeAPIKey     = "ApiKey"
+)
+
+// create a new key, returning the full key and the prefix kept for display
+func generateAPIKey() (string, string, error) {
+	token, err := generateRandomToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	key := apiKeyPrefix + token
+	return key, key[:apiKeyDisplayLength], nil
+}
+
+// check an api key and build the claims it stands for. The key only gets the
+// scopes it was minted with that the user still holds through their roles
+func (app *application) authenticateAPIKey(key string) (*Claims, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, errors.New("invalid api key")
+	}
+
+	apiKey, err := app.DB.UseAPIKey(hashToken(key))
+	if err != nil {
+		return nil, errors.New("invalid api key")
+	}
+
+	user, err := app.DB.GetUSerById(apiKey.UserID)
+	if err != nil || user.Disabled {
+		return nil, errors.New("invalid api key")
+	}
+
+	roles, err := app.DB.GetUserRoles(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// scopes were checked against the session that minted the key, which is
+	// where any mfa requirement was met
+	names, permissions := grantedBy(roles, true)
+
+	held := make(map[string]bool)
+	for _, p := range permissions {
+		held[p] = true
+	}
+
+	claims := &Claims{
+		Name:        fmt.Sprintf("%s %s", user.FirstName, user.LastName),
+		Roles:       names,
+		Permissions: []string{},
+		Type:        tokenTypeAPIKey,
+	}
+	claims.Subject = fmt.Sprint(user.ID)
+	claims.ID = fmt.Sprintf("apikey:%d", apiKey.ID)
+
+	for _, scope := range apiKey.Scopes {
+		if held[scope] {
+			claims.Permissions = append(claims.Permissions, scope)
+		}
+	}
+
+	return claims, nil
+}
+
+func (app *application) myAPIKeys(w http.ResponseWriter, r *http.Request) {
+	principal, ok := app.principal(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := app.DB.GetUserAPIKeys(principal.UserID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, keys)
+}
+
+func (app *application) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	principal, ok := app.principal(w, r)
+	if !ok {
+		return
+	}
+
+	// keys can't mint more keys, a person has to log in for that
+	if principal.APIKey() {
+		app.errorJSON(w, errors.New("api keys can only be created from a logged in session"), http.StatusForbidden)
+		return
+	}
+
+	var payload struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload.Name = strings.TrimSpace(payload.Name)
+	if payload.Name == "" {
+		app.errorJSON(w, errors.New("a name is required"))
+		return
+	}
+	if len(payload.Scopes) == 0 {
+		app.errorJSON(w, errors.New("at least one scope is required"))
+		return
+	}
+
+	// a key can never do more than the session creating it
+	for _, scope := range payload.Scopes {
+		if !principal.HasPermission(scope) {
+			app.errorJSON(w, fmt.Errorf("you can't grant the %q scope", scope), http.StatusForbidden)
+			return
+		}
+	}
+
+	key, prefix, err := generateAPIKey()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	apiKey := models.APIKey{
+		UserID:    principal.UserID,
+		Name:      payload.Name,
+		Prefix:    prefix,
+		Scopes:    payload.Scopes,
+		CreatedAt: time.Now(),
+	}
+
+	apiKey.ID, err = app.DB.InsertAPIKey(apiKey, hashToken(key))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// the full key is only ever shown in this response
+	var resp = struct {
+		Key    string        `json:"key"`
+		APIKey models.APIKey `json:"api_key"`
+	}{
+		Key:    key,
+		APIKey: apiKey,
+	}
+
+	_ = app.writeJSON(w, http.StatusCreated, resp)
+}
+
+func (app *application) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	principal, ok := app.principal(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.DB.RevokeAPIKey(principal.UserID, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("api key not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "api key revoked",
+	}
+
+	_ = app.writeJSON(w, http.StatusAccepted, resp)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/toluhikay/go-react/internal/models"
+)
+
+// audit actions
+const (
+	auditLogin                = "auth.login"
+	auditLoginFailed          = "auth.login_failed"
+	auditLogout               = "auth.logout"
+	auditRefresh              = "auth.refresh"
+	auditRefreshReuse         = "auth.refresh_reuse"
+	auditImpersonate          = "auth.impersonate"
+	auditImpersonationBlocked = "auth.impersonation_blocked"
+	auditMovieCreate          = "movie.create"
+	auditMovieUpdate          = "movie.update"
+	auditMovieDelete          = "movie.delete"
+	auditMovieGenres          = "movie.genres"
+	auditUserCreate           = "user.create"
+	auditUserUpdate           = "user.update"
+	auditUserDisable          = "user.disable"
+	auditUserEnable           = "user.enable"
+	auditUserPasswordReset    = "user.password_reset"
+	auditUserUnlock           = "user.unlock"
+	auditUserDelete           = "user.delete"
+)
+
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 200
+)
+
+// something worth recording in the audit log
+type auditEvent struct {
+	Action string
+	// who did it, when left at 0 the principal in the request context is used
+	ActorID  int
+	Entity   string
+	EntityID interface{}
+	// the state of the entity before and after, either can be nil
+	Before interface{}
+	After  interface{}
+}
+
+// write an entry to the audit log. Failing to audit shouldn't undo a change
+// that already happened, so errors are only logged
+func (app *application) audit(r *http.Request, event auditEvent) {
+	entry := models.AuditEntry{
+		ActorID:   event.ActorID,
+		Action:    event.Action,
+		Entity:    event.Entity,
+		IPAddress: clientIP(r),
+		RequestID: middleware.GetReqID(r.Context()),
+		CreatedAt: time.Now(),
+	}
+
+	if event.EntityID != nil {
+		entry.EntityID = fmt.Sprint(event.EntityID)
+	}
+
+	if principal, ok := principalFromContext(r.Context()); ok {
+		if entry.ActorID == 0 {
+			entry.ActorID = principal.UserID
+		}
+		entry.ImpersonatorID = principal.ActorID
+	}
+
+	var err error
+	entry.Before, entry.After, entry.Diff, err = auditDiff(event.Before, event.After)
+	if err != nil {
+		log.Printf("audit %s: %v", event.Action, err)
+	}
+
+	err = app.DB.InsertAuditEntry(entry)
+	if err != nil {
+		log.Printf("audit %s: %v", event.Action, err)
+	}
+}
+
+// marshal both states and work out which top level fields changed, as
+// {"field": {"from": ..., "to": ...}}
+func auditDiff(before, after interface{}) (json.RawMessage, json.RawMessage, json.RawMessage, error) {
+	beforeJSON, beforeFields, err := auditFields(before)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	afterJSON, afterFields, err := auditFields(after)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// nothing to compare unless at least one side is an object
+	if beforeFields == nil && afterFields == nil {
+		return beforeJSON, afterJSON, nil, nil
+	}
+
+	type change struct {
+		From interface{} `json:"from"`
+		To   interface{} `json:"to"`
+	}
+
+	diff := make(map[string]change)
+	for key, value := range beforeFields {
+		if !reflect.DeepEqual(value, afterFields[key]) {
+			diff[key] = change{From: value, To: afterFields[key]}
+		}
+	}
+	for key, value := range afterFields {
+		if _, ok := beforeFields[key]; !ok {
+			diff[key] = change{From: nil, To: value}
+		}
+	}
+
+	if len(diff) == 0 {
+		return beforeJSON, afterJSON, nil, nil
+	}
+
+	diffJSON, err := json.Marshal(diff)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return beforeJSON, afterJSON, diffJSON, nil
+}
+
+// marshal a value for the log, and decode it again as an object when it is one
+func auditFields(value interface{}) (json.RawMessage, map[string]interface{}, error) {
+	if value == nil || (reflect.ValueOf(value).Kind() == reflect.Ptr && reflect.ValueOf(value).IsNil()) {
+		return nil, nil, nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var fields map[string]interface{}
+	if json.Unmarshal(data, &fields) != nil {
+		fields = nil
+	}
+
+	return data, fields, nil
+}
+
+func (app *application) auditLog(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := models.AuditFilter{
+		Entity:   query.Get("entity"),
+		EntityID: query.Get("entity_id"),
+		Action:   query.Get("action"),
+	}
+
+	var err error
+	filter.ActorID, err = queryInt(r, "actor_id", 0)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	filter.Limit, err = queryInt(r, "limit", defaultAuditLimit)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	if filter.Limit > maxAuditLimit {
+		filter.Limit = maxAuditLimit
+	}
+
+	if before := query.Get("before"); before != "" {
+		filter.Before, err = strconv.ParseInt(before, 10, 64)
+		if err != nil || filter.Before < 1 {
+			app.errorJSON(w, errors.New("before must be a positive integer"))
+			return
+		}
+	}
+
+	for key, t := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		value := query.Get(key)
+		if value == "" {
+			continue
+		}
+		*t, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			app.errorJSON(w, fmt.Errorf("%s must be an RFC 3339 time", key))
+			return
+		}
+	}
+
+	entries, err := app.DB.GetAuditEntries(filter)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	var payload = struct {
+		Entries  []*models.AuditEntry `json:"entries"`
+		Metadata struct {
+			// pass back as before to get the next, older page
+			NextBefore int64 `json:"next_before,omitempty"`
+		} `json:"metadata"`
+	}{
+		Entries: entries,
+	}
+	if len(entries) == filter.Limit {
+		payload.Metadata.NextBefore = entries[len(entries)-1].ID
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
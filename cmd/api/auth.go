@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,7 +14,7 @@ import (
 type Auth struct {
 	Issuer        string
 	Audience      string
-	Secret        string
+	Keys          *KeySet
 	TokenExpiry   time.Duration
 	RefreshExpiry time.Duration
 	CookieDomain  string
@@ -22,70 +23,226 @@ type Auth struct {
 }
 
 type jwtUSer struct {
-	ID        int    `json:"id"`
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
+	ID          int      `json:"id"`
+	FirstName   string   `json:"first_name"`
+	LastName    string   `json:"last_name"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+	// how the user logged in, e.g. pwd or pwd plus mfa
+	AMR []string `json:"amr"`
 }
 
 type TokenPairs struct {
 	Token        string `json:"token"`
 	RefreshToken string `json:"refresh_token"`
+	// jti and expiry of the refresh token, kept server side so it can be rotated and revoked
+	RefreshTokenID     string    `json:"-"`
+	RefreshTokenExpiry time.Time `json:"-"`
 }
 
 type Claims struct {
+	Name        string   `json:"name,omitempty"`
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	SessionID   string   `json:"sid,omitempty"`
+	Type        string   `json:"type,omitempty"`
+	AMR         []string `json:"amr,omitempty"`
+	// set when an admin is acting as the subject, see RFC 8693
+	Actor *Actor `json:"act,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func (j *Auth) GenerateTokens(user *jwtUSer) (TokenPairs, error) {
-	//create a token
-	token := jwt.New(jwt.SigningMethodHS256)
+// the user really making the requests when a token is used for impersonation
+type Actor struct {
+	Subject string `json:"sub"`
+	Name    string `json:"name,omitempty"`
+}
+
+// check if the token was issued to an admin acting as the subject
+func (c *Claims) Impersonating() bool {
+	return c.Actor != nil
+}
+
+// check if the token was issued to a user holding the given role
+func (c *Claims) HasRole(role string) bool {
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// check if the user passed a second factor when logging in
+func (c *Claims) MFA() bool {
+	return hasMFA(c.AMR)
+}
+
+func hasMFA(amr []string) bool {
+	for _, m := range amr {
+		if m == amrMFA {
+			return true
+		}
+	}
+	return false
+}
+
+// check if any of the user's roles grants the given permission
+func (c *Claims) HasPermission(permission string) bool {
+	for _, p := range c.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
 
+func (j *Auth) GenerateTokens(user *jwtUSer, sessionID string) (TokenPairs, error) {
 	// Set the token claims
-	claims := token.Claims.(jwt.MapClaims)
+	claims := jwt.MapClaims{}
 	claims["name"] = fmt.Sprintf("%s %s", user.FirstName, user.LastName)
 	claims["sub"] = fmt.Sprint(user.ID)
 	claims["aud"] = j.Audience
 	claims["iss"] = j.Issuer
 	claims["iat"] = time.Now().UTC().Unix()
 	claims["type"] = "JWT"
+	claims["roles"] = user.Roles
+	claims["permissions"] = user.Permissions
+	claims["jti"] = newTokenID()
+	claims["sid"] = sessionID
+	claims["amr"] = user.AMR
 
 	// set expirty for token
 	claims["exp"] = time.Now().UTC().Add(j.TokenExpiry).Unix()
 
 	// create a signed access token
-	signedToken, err := token.SignedString([]byte(j.Secret))
+	signedToken, err := j.Keys.Sign(claims)
 	if err != nil {
 		return TokenPairs{}, err
 	}
 
 	// same step as above for refresh token
-	// create refresh token
-	refreshToken := jwt.New(jwt.SigningMethodHS256)
-
 	// set refresh token claims
-	refreshClaims := refreshToken.Claims.(jwt.MapClaims)
+	refreshClaims := jwt.MapClaims{}
 	refreshClaims["sub"] = fmt.Sprint(user.ID)
 	refreshClaims["iat"] = time.Now().UTC().Unix()
+	refreshClaims["iss"] = j.Issuer
+
+	// every refresh token gets its own id so it can only be used once
+	refreshTokenID := newTokenID()
+	refreshClaims["jti"] = refreshTokenID
+	refreshClaims["sid"] = sessionID
+	refreshClaims["amr"] = user.AMR
 
 	// set expiry for token
-	refreshClaims["exp"] = time.Now().UTC().Add(j.RefreshExpiry).Unix()
+	refreshExpiry := time.Now().UTC().Add(j.RefreshExpiry)
+	refreshClaims["exp"] = refreshExpiry.Unix()
 
 	// create a signed refreshtoken
-	signedRefreshToken, err := refreshToken.SignedString([]byte(j.Secret))
+	signedRefreshToken, err := j.Keys.Sign(refreshClaims)
 	if err != nil {
 		return TokenPairs{}, err
 	}
 
 	// create token pairs
 	tokenPairs := TokenPairs{
-		Token:        signedToken,
-		RefreshToken: signedRefreshToken,
+		Token:              signedToken,
+		RefreshToken:       signedRefreshToken,
+		RefreshTokenID:     refreshTokenID,
+		RefreshTokenExpiry: refreshExpiry,
 	}
 
 	// return token pairs
 	return tokenPairs, nil
 }
 
+// create a short lived access token for the user with the admin acting as them
+// in the act claim. There is no refresh token, once it expires the admin has
+// to ask for a new one
+func (j *Auth) GenerateImpersonationToken(user *jwtUSer, actor Actor, ttl time.Duration) (string, time.Time, error) {
+	expiry := time.Now().UTC().Add(ttl)
+
+	claims := jwt.MapClaims{}
+	claims["name"] = fmt.Sprintf("%s %s", user.FirstName, user.LastName)
+	claims["sub"] = fmt.Sprint(user.ID)
+	claims["aud"] = j.Audience
+	claims["iss"] = j.Issuer
+	claims["iat"] = time.Now().UTC().Unix()
+	claims["type"] = "JWT"
+	claims["roles"] = user.Roles
+	claims["permissions"] = user.Permissions
+	claims["jti"] = newTokenID()
+	claims["amr"] = user.AMR
+	claims["act"] = actor
+	claims["exp"] = expiry.Unix()
+
+	token, err := j.Keys.Sign(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, expiry, nil
+}
+
+// token purposes, so a token minted for one flow can't be replayed in another
+const (
+	purposeEmailVerification = "email_verification"
+	purposeMFAChallenge      = "mfa_challenge"
+	// the challenge after a single sign-on, the first factor was the provider
+	purposeSSOMFAChallenge = "sso_mfa_challenge"
+)
+
+// authentication methods recorded in the amr claim
+const (
+	amrPassword = "pwd"
+	amrOTP      = "otp"
+	amrMFA      = "mfa"
+)
+
+type purposeClaims struct {
+	Purpose string `json:"purpose"`
+	Email   string `json:"email,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// create a signed, short lived token for a single purpose such as an email link
+func (j *Auth) GeneratePurposeToken(purpose string, userID int, email string, ttl time.Duration) (string, error) {
+	claims := purposeClaims{
+		Purpose: purpose,
+		Email:   email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   fmt.Sprint(userID),
+			Issuer:    j.Issuer,
+			Audience:  jwt.ClaimStrings{j.Audience},
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(ttl)),
+		},
+	}
+
+	return j.Keys.Sign(claims)
+}
+
+// verify a purpose token and return the user id and email it was issued for
+func (j *Auth) ParsePurposeToken(purpose, token string) (int, string, error) {
+	claims := &purposeClaims{}
+
+	_, err := jwt.ParseWithClaims(token, claims, j.Keys.Keyfunc)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if claims.Purpose != purpose || claims.Issuer != j.Issuer {
+		return 0, "", errors.New("invalid token")
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return 0, "", errors.New("invalid token")
+	}
+
+	return userID, claims.Email, nil
+}
+
 // create a function to generate refresh token
 func (j *Auth) GetRefreshCookie(refreshToken string) *http.Cookie {
 	return &http.Cookie{
@@ -101,6 +258,22 @@ func (j *Auth) GetRefreshCookie(refreshToken string) *http.Cookie {
 	}
 }
 
+// verify a refresh token from the cookie and return its claims
+func (j *Auth) ParseRefreshToken(refreshToken string) (*Claims, error) {
+	claims := &Claims{}
+
+	_, err := jwt.ParseWithClaims(refreshToken, claims, j.Keys.Keyfunc)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.ID == "" || claims.SessionID == "" {
+		return nil, errors.New("invalid refresh token")
+	}
+
+	return claims, nil
+}
+
 // delete the token
 func (j *Auth) GetExpiredRefreshCookie() *http.Cookie {
 	return &http.Cookie{
@@ -122,7 +295,7 @@ func (j *Auth) GetTokenFromHeaderAndVerify(w http.ResponseWriter, r *http.Reques
 	w.Header().Add("Vary", "Authorization")
 
 	// get auth header
-	authHeader := w.Header().Get("Authorization")
+	authHeader := r.Header.Get("Authorization")
 
 	// check for things in the header
 	if authHeader == "" {
@@ -142,30 +315,44 @@ func (j *Auth) GetTokenFromHeaderAndVerify(w http.ResponseWriter, r *http.Reques
 
 	token := headerParts[1]
 
+	claims, err := j.VerifyAccessToken(token)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, claims, nil
+}
+
+// verify an access token, wherever it was sent
+func (j *Auth) VerifyAccessToken(token string) (*Claims, error) {
 	// create a store for  claims
 	claims := &Claims{}
 
 	// parse token
-	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		// first validate signing algo
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
-		}
-
-		return []byte(j.Secret), nil
-	})
+	// parse token, the key set picks the key by kid and checks the signing algo
+	_, err := jwt.ParseWithClaims(token, claims, j.Keys.Keyfunc)
 
 	if err != nil {
 		if strings.HasPrefix(err.Error(), "token is expired by") {
-			return "", nil, errors.New("expired token")
+			return nil, errors.New("expired token")
 		}
-		return "", nil, err
+		return nil, err
 	}
 
 	// check to see if I issued the token
 	if claims.Issuer != j.Issuer {
-		return "", nil, errors.New("invalid issuer")
+		return nil, errors.New("invalid issuer")
 	}
 
-	return token, claims, nil
+	// refresh and single purpose tokens share the signing keys, so make sure this is an access token
+	if claims.Type != "JWT" || !claims.VerifyAudience(j.Audience, true) {
+		return nil, errors.New("invalid token")
+	}
+
+	// the subject is who the request acts as, the actor is who is really behind it
+	if claims.Actor != nil && (claims.Actor.Subject == "" || claims.Actor.Subject == claims.Subject) {
+		return nil, errors.New("invalid token actor")
+	}
+
+	return claims, nil
 }
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/toluhikay/go-react/internal/graph"
+	"github.com/toluhikay/go-react/internal/models"
+)
+
+// a graphql request as sent over http
+type graphRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+	Extensions    graphExtensions        `json:"extensions"`
+}
+
+type graphExtensions struct {
+	// automatic persisted queries, as sent by apollo clients
+	PersistedQuery *struct {
+		Version    int    `json:"version"`
+		Sha256Hash string `json:"sha256Hash"`
+	} `json:"persistedQuery"`
+}
+
+// read a graphql request from the query string for GET, or from the body
+// either as json or, with any other content type, as the bare query
+func (app *application) readGraphRequest(w http.ResponseWriter, r *http.Request) (graphRequest, error) {
+	var req graphRequest
+
+	if r.Method == http.MethodGet {
+		values := r.URL.Query()
+		req.Query = values.Get("query")
+		req.OperationName = values.Get("operationName")
+		if variables := values.Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				return req, errors.New("variables must be a json object")
+			}
+		}
+		if extensions := values.Get("extensions"); extensions != "" {
+			if err := json.Unmarshal([]byte(extensions), &req.Extensions); err != nil {
+				return req, errors.New("extensions must be a json object")
+			}
+		}
+		return req, nil
+	}
+
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType == "application/json" {
+		err := app.readJSON(w, r, &req)
+		return req, err
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1024*1024))
+	if err != nil {
+		return req, err
+	}
+	req.Query = string(body)
+
+	return req, nil
+}
+
+func (app *application) graphQL(w http.ResponseWriter, r *http.Request) {
+	// subscriptions are served over a websocket on the same path
+	if websocket.IsWebSocketUpgrade(r) {
+		app.graphSocket(w, r)
+		return
+	}
+
+	req, err := app.readGraphRequest(w, r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	g, err := app.graphFor(r, req)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	g.QueryOnly = r.Method == http.MethodGet
+
+	result, err := g.Query(graphContext(r))
+	if errors.Is(err, graph.ErrQueryOnly) {
+		app.errorJSON(w, err, http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// errors are reported in the result the way graphql clients expect
+	_ = app.writeJSON(w, http.StatusOK, result)
+}
+
+// the graph set up to run a request, r is the request it came in on
+func (app *application) graphFor(r *http.Request, req graphRequest) (*graph.Graph, error) {
+	g := app.graph.Request(req.Query, req.OperationName, req.Variables)
+	if pq := req.Extensions.PersistedQuery; pq != nil {
+		if pq.Version != 1 {
+			return nil, errors.New("unsupported persisted query version")
+		}
+		g.QueryHash = strings.ToLower(pq.Sha256Hash)
+	}
+	g.Hooks = app.graphHooks(r)
+	return g, nil
+}
+
+// the request context with the principal, if there is one, as the graph's viewer
+func graphContext(r *http.Request) context.Context {
+	ctx := r.Context()
+	if principal, ok := principalFromContext(ctx); ok {
+		ctx = graph.WithViewer(ctx, &graph.Viewer{
+			UserID:      principal.UserID,
+			Permissions: principal.Scopes,
+			ReadOnly:    principal.Impersonating(),
+		})
+	}
+	return ctx
+}
+
+// what happens around a change made by a mutation, so changes through the
+// graph are handled the same way as the rest handlers handle them
+func (app *application) graphHooks(r *http.Request) graph.Hooks {
+	return graph.Hooks{
+		PrepareMovie: func(movie *models.Movie) {
+			*movie = app.getPoster(*movie)
+		},
+		MovieChanged: func(before, after *models.Movie) {
+			app.movieChanged(r, before, after)
+		},
+		GenresChanged: func(movieID int, before []*models.Genre, after []int) {
+			app.auditMovieGenres(r, movieID, before, after)
+		},
+	}
+}
+
+// read field costs given as "Type.field=cost,Type.field=cost"
+func parseGraphCosts(s string) (map[string]int, error) {
+	costs := make(map[string]int)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		field, value, ok := strings.Cut(pair, "=")
+		cost, err := strconv.Atoi(value)
+		if !ok || !strings.Contains(field, ".") || err != nil || cost < 0 {
+			return nil, fmt.Errorf("invalid graphql field cost %q, expected Type.field=cost", pair)
+		}
+		costs[field] = cost
+	}
+	return costs, nil
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/graphql-go/graphql/gqlerrors"
+)
+
+// graphql over a websocket with the graphql-transport-ws protocol, which is
+// what graphql-ws clients speak. Subscriptions need it, queries and mutations
+// can be sent over it too
+const graphSocketProtocol = "graphql-transport-ws"
+
+const (
+	// how long a client has to send connection_init after connecting
+	graphSocketInitTimeout = time.Second * 10
+	graphSocketWriteWait   = time.Second * 10
+	graphSocketMaxMessage  = 1024 * 1024
+	// how often the credentials a connection was opened with are checked again
+	graphSocketRecheck = time.Minute
+)
+
+// close codes from the protocol
+const (
+	closeInvalidMessage   = 4400
+	closeUnauthorized     = 4401
+	closeForbidden        = 4403
+	closeInitTimeout      = 4408
+	closeSubscriberExists = 4409
+	closeTooManyInits     = 4429
+)
+
+// message types from the protocol
+const (
+	msgConnectionInit = "connection_init"
+	msgConnectionAck  = "connection_ack"
+	msgPing           = "ping"
+	msgPong           = "pong"
+	msgSubscribe      = "subscribe"
+	msgNext           = "next"
+	msgError          = "error"
+	msgComplete       = "complete"
+)
+
+type graphMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// what a client sends with connection_init. Browsers can't set headers on a
+// websocket, so the credentials the rest api takes in the Authorization
+// header come in here instead
+type graphSocketInit struct {
+	Authorization string `json:"Authorization"`
+}
+
+var graphUpgrader = websocket.Upgrader{
+	Subprotocols: []string{graphSocketProtocol},
+	// credentials are sent in connection_init rather than with cookies, so
+	// another site connecting can't act as the user
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// one websocket connection and the subscriptions running on it
+type graphSocket struct {
+	app  *application
+	conn *websocket.Conn
+	// the upgrade request, with the principal in its context once the
+	// connection is initialised. Hooks and audit entries use it
+	r *http.Request
+
+	// closes the connection when its token expires
+	expiry *time.Timer
+
+	writeMu sync.Mutex
+
+	mu    sync.Mutex
+	init  bool
+	acked bool
+	subs  map[string]*graphSubscription
+}
+
+type graphSubscription struct {
+	cancel context.CancelFunc
+}
+
+func (app *application) graphSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := graphUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has already sent an error response
+		return
+	}
+	defer conn.Close()
+
+	s := &graphSocket{
+		app:  app,
+		conn: conn,
+		r:    r,
+		subs: make(map[string]*graphSubscription),
+	}
+
+	if conn.Subprotocol() != graphSocketProtocol {
+		s.close(websocket.CloseProtocolError, "Subprotocol not acceptable")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	s.run(ctx)
+
+	if s.expiry != nil {
+		s.expiry.Stop()
+	}
+}
+
+// read messages until the connection closes, the subscriptions running are
+// stopped when ctx is cancelled on return
+func (s *graphSocket) run(ctx context.Context) {
+	s.conn.SetReadLimit(graphSocketMaxMessage)
+
+	initTimer := time.AfterFunc(graphSocketInitTimeout, func() {
+		s.mu.Lock()
+		acked := s.acked
+		s.mu.Unlock()
+		if !acked {
+			s.close(closeInitTimeout, "Connection initialisation timeout")
+		}
+	})
+	defer initTimer.Stop()
+
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var msg graphMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			s.close(closeInvalidMessage, "Invalid message received")
+			return
+		}
+
+		switch msg.Type {
+		case msgConnectionInit:
+			if !s.connectionInit(ctx, msg) {
+				return
+			}
+
+		case msgPing:
+			s.send(graphMessage{Type: msgPong})
+
+		case msgPong:
+
+		case msgSubscribe:
+			if !s.subscribe(ctx, msg) {
+				return
+			}
+
+		case msgComplete:
+			s.mu.Lock()
+			if sub, ok := s.subs[msg.ID]; ok {
+				sub.cancel()
+				delete(s.subs, msg.ID)
+			}
+			s.mu.Unlock()
+
+		default:
+			s.close(closeInvalidMessage, "Invalid message received")
+			return
+		}
+	}
+}
+
+// authenticate the connection with the credentials in connection_init. A
+// connection without credentials can still run what anyone can, the graph
+// refuses the rest. false means the connection has been closed
+func (s *graphSocket) connectionInit(ctx context.Context, msg graphMessage) bool {
+	s.mu.Lock()
+	if s.init {
+		s.mu.Unlock()
+		s.close(closeTooManyInits, "Too many initialisation requests")
+		return false
+	}
+	s.init = true
+	s.mu.Unlock()
+
+	var init graphSocketInit
+	if len(msg.Payload) > 0 && string(msg.Payload) != "null" {
+		if err := json.Unmarshal(msg.Payload, &init); err != nil {
+			s.close(closeInvalidMessage, "Invalid message received")
+			return false
+		}
+	}
+
+	if init.Authorization != "" {
+		principal, err := s.app.authenticateCredentials(init.Authorization)
+		if err != nil {
+			s.close(closeForbidden, "Forbidden")
+			return false
+		}
+		s.r = s.r.WithContext(contextWithPrincipal(s.r.Context(), principal))
+
+		// the connection lasts only as long as the token it was opened with
+		if !principal.Expires.IsZero() {
+			s.expiry = time.AfterFunc(time.Until(principal.Expires), func() {
+				s.close(closeForbidden, "Token expired")
+			})
+		}
+
+		go s.watchCredentials(ctx, init.Authorization)
+	}
+
+	s.mu.Lock()
+	s.acked = true
+	s.mu.Unlock()
+
+	s.send(graphMessage{Type: msgConnectionAck})
+	return true
+}
+
+// check the credentials the connection was opened with every so often. API
+// keys don't expire, and a revoked key or session or a disabled user shouldn't
+// keep receiving events, so the connection is closed once they stop working
+func (s *graphSocket) watchCredentials(ctx context.Context, authorization string) {
+	ticker := time.NewTicker(graphSocketRecheck)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := s.app.authenticateCredentials(authorization)
+			if err != nil {
+				s.close(closeForbidden, "Forbidden")
+				return
+			}
+		}
+	}
+}
+
+// start running an operation, sending its results as they come. false means
+// the connection has been closed
+func (s *graphSocket) subscribe(ctx context.Context, msg graphMessage) bool {
+	var req graphRequest
+	if msg.ID == "" || json.Unmarshal(msg.Payload, &req) != nil {
+		s.close(closeInvalidMessage, "Invalid message received")
+		return false
+	}
+
+	s.mu.Lock()
+	if !s.acked {
+		s.mu.Unlock()
+		s.close(closeUnauthorized, "Unauthorized")
+		return false
+	}
+	if _, ok := s.subs[msg.ID]; ok {
+		s.mu.Unlock()
+		s.close(closeSubscriberExists, fmt.Sprintf("Subscriber for %s already exists", msg.ID))
+		return false
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	sub := &graphSubscription{cancel: cancel}
+	s.subs[msg.ID] = sub
+	s.mu.Unlock()
+
+	r := s.r
+	go func() {
+		defer s.done(msg.ID, sub)
+
+		g, err := s.app.graphFor(r, req)
+		if err != nil {
+			s.sendErrors(msg.ID, []gqlerrors.FormattedError{gqlerrors.NewFormattedError(err.Error())})
+			return
+		}
+
+		// ctx stops with the subscription, the principal comes from the connection
+		if principal, ok := principalFromContext(r.Context()); ok {
+			ctx = contextWithPrincipal(ctx, principal)
+		}
+		results, errs, err := g.Subscribe(graphContext(r.WithContext(ctx)))
+		if err != nil {
+			errs = []gqlerrors.FormattedError{gqlerrors.NewFormattedError(err.Error())}
+		}
+		if len(errs) > 0 {
+			s.sendErrors(msg.ID, errs)
+			return
+		}
+
+		for result := range results {
+			// nothing more is sent once the client has completed it
+			if ctx.Err() != nil {
+				return
+			}
+			payload, err := json.Marshal(result)
+			if err != nil {
+				log.Printf("graph socket: %v", err)
+				continue
+			}
+			s.send(graphMessage{ID: msg.ID, Type: msgNext, Payload: payload})
+		}
+
+		if ctx.Err() == nil {
+			s.send(graphMessage{ID: msg.ID, Type: msgComplete})
+		}
+	}()
+
+	return true
+}
+
+// forget a subscription that has finished, leaving the id free to use again
+func (s *graphSocket) done(id string, sub *graphSubscription) {
+	sub.cancel()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// the client may have completed it and used the id again since
+	if s.subs[id] == sub {
+		delete(s.subs, id)
+	}
+}
+
+func (s *graphSocket) sendErrors(id string, errs []gqlerrors.FormattedError) {
+	payload, err := json.Marshal(errs)
+	if err != nil {
+		log.Printf("graph socket: %v", err)
+		return
+	}
+	s.send(graphMessage{ID: id, Type: msgError, Payload: payload})
+}
+
+func (s *graphSocket) send(msg graphMessage) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	_ = s.conn.SetWriteDeadline(time.Now().Add(graphSocketWriteWait))
+	if err := s.conn.WriteJSON(msg); err != nil {
+		// the read loop sees the connection go and stops everything
+		s.conn.Close()
+	}
+}
+
+// close the connection with a close code from the protocol
+func (s *graphSocket) close(code int, reason string) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	_ = s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(graphSocketWriteWait))
+	s.conn.Close()
+}
@@ -1,17 +1,20 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/golang-jwt/jwt/v4"
+	"github.com/toluhikay/go-react/internal/events"
 	"github.com/toluhikay/go-react/internal/models"
 )
 
@@ -30,12 +33,7 @@ func (app *application) Home(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) AllMovies(w http.ResponseWriter, r *http.Request) {
-	movies, err := app.DB.AllMovies()
-	if err != nil {
-		app.errorJSON(w, err)
-		return
-	}
-	_ = app.writeJSON(w, http.StatusOK, movies)
+	app.listMovies(w, r)
 }
 
 func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
@@ -45,14 +43,24 @@ func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
 		Password string `json:"password"`
 	}
 
-	err := app.readJSON(w, r, reqpayload)
+	err := app.readJSON(w, r, &reqpayload)
 	if err != nil {
 		app.errorJSON(w, err)
+		return
+	}
+
+	// emails are stored lowercased, and the lockout is per account however it's typed
+	reqpayload.Email = strings.ToLower(strings.TrimSpace(reqpayload.Email))
+
+	// don't even look at the password while the ip or account is locked out
+	if app.loginLocked(w, r, reqpayload.Email) {
+		return
 	}
 
 	// validate the user against the database
 	user, err := app.DB.GetUserByEMail(reqpayload.Email)
 	if err != nil {
+		app.loginFailed(r, reqpayload.Email)
 		app.errorJSON(w, errors.New("invalid credential"))
 		return
 	}
@@ -60,23 +68,61 @@ func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
 	// check password
 	valid, err := user.PasswordMatch(reqpayload.Password)
 	if err != nil || !valid {
+		app.loginFailed(r, reqpayload.Email)
 		app.errorJSON(w, errors.New("invalid credentials"))
 		return
 	}
 
-	u := jwtUSer{
-		ID:        user.ID,
-		FirstName: user.FirstName,
-		LastName:  user.LastName,
+	// accounts stay locked out until the email address is verified
+	if !user.EmailVerified {
+		app.errorJSON(w, errors.New("email address has not been verified"), http.StatusForbidden)
+		return
+	}
+
+	if user.Disabled {
+		app.errorJSON(w, errors.New("account is disabled"), http.StatusForbidden)
+		return
+	}
+
+	// the password can't be trusted until the user has chosen a new one
+	if user.PasswordResetRequired {
+		app.errorJSON(w, errors.New("a password reset is required, check your email for a reset link"), http.StatusForbidden)
+		return
+	}
+
+	// with mfa on the password only gets a challenge, the tokens come after the second step
+	if user.TOTPEnabled {
+		app.mfaChallenge(w, user, purposeMFAChallenge)
+		return
 	}
 
-	// generate token
-	tokens, err := app.auth.GenerateTokens(&u)
+	app.login(w, r, user, []string{amrPassword})
+}
+
+// start a new session, set the refresh cookie and send the tokens to the user
+func (app *application) login(w http.ResponseWriter, r *http.Request, user *models.User, amr []string) {
+	// checked here as well since not every way of logging in goes through authenticate
+	if user.Disabled {
+		app.errorJSON(w, errors.New("account is disabled"), http.StatusForbidden)
+		return
+	}
+
+	// start a new session and generate token
+	tokens, err := app.startSession(r, user, amr)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
+	app.loginSucceeded(user.Email)
+	app.audit(r, auditEvent{
+		Action:   auditLogin,
+		ActorID:  user.ID,
+		Entity:   "user",
+		EntityID: user.ID,
+		After:    map[string]interface{}{"amr": amr},
+	})
+
 	// set the cookie and send to the user
 	refreshCookie := app.auth.GetRefreshCookie(tokens.RefreshToken)
 
@@ -84,72 +130,213 @@ func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
 	app.writeJSON(w, http.StatusOK, tokens)
 }
 
-func (app *application) refreshToken(w http.ResponseWriter, r *http.Request) {
-	// first thing is to range over the cookies sent back by the user then check for our own cookie
-	for _, cookie := range r.Cookies() {
-		if cookie.Name == app.auth.CookieName {
-			claims := &Claims{}
-			refreshToken := cookie.Value
-
-			// parser the token to get the claims
-			_, err := jwt.ParseWithClaims(refreshToken, claims, func(t *jwt.Token) (interface{}, error) {
-				return []byte(app.JWTSecret), nil
-			})
-			if err != nil {
-				app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
-				return
-			}
+// start a server side session for the user and issue its first token pair
+func (app *application) startSession(r *http.Request, user *models.User, amr []string) (TokenPairs, error) {
+	u, err := app.jwtUserFor(user, amr)
+	if err != nil {
+		return TokenPairs{}, err
+	}
 
-			// get user id from token claims
-			userId, err := strconv.Atoi(claims.Subject)
-			if err != nil {
-				app.errorJSON(w, errors.New("unknown user"), http.StatusUnauthorized)
-				return
-			}
+	session := models.Session{
+		ID:         newTokenID(),
+		UserID:     user.ID,
+		UserAgent:  r.UserAgent(),
+		IPAddress:  clientIP(r),
+		CreatedAt:  time.Now(),
+		LastUsedAt: time.Now(),
+	}
 
-			user, err := app.DB.GetUSerById(userId)
-			if err != nil {
-				app.errorJSON(w, errors.New("unknown user"), http.StatusUnauthorized)
-				return
-			}
+	tokens, err := app.auth.GenerateTokens(&u, session.ID)
+	if err != nil {
+		return TokenPairs{}, err
+	}
 
-			// generate a new jwt user
-			u := jwtUSer{
-				ID:        user.ID,
-				FirstName: user.FirstName,
-				LastName:  user.LastName,
-			}
+	err = app.DB.InsertSession(session, models.RefreshToken{
+		ID:        tokens.RefreshTokenID,
+		SessionID: session.ID,
+		UserID:    user.ID,
+		ExpiresAt: tokens.RefreshTokenExpiry,
+		CreatedAt: session.CreatedAt,
+	})
+	if err != nil {
+		return TokenPairs{}, err
+	}
 
-			// generate new token pairs
-			tokenPairs, err := app.auth.GenerateTokens(&u)
-			if err != nil {
-				app.errorJSON(w, errors.New("error generating token"), http.StatusUnauthorized)
-				return
+	return tokens, nil
+}
+
+// build the jwt user for a db user along with the roles and permissions it holds.
+// Roles that require mfa are left out unless the user logged in with a second factor
+func (app *application) jwtUserFor(user *models.User, amr []string) (jwtUSer, error) {
+	roles, err := app.DB.GetUserRoles(user.ID)
+	if err != nil {
+		return jwtUSer{}, err
+	}
+
+	u := jwtUSer{
+		ID:        user.ID,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		AMR:       amr,
+	}
+	u.Roles, u.Permissions = grantedBy(roles, hasMFA(amr))
+
+	return u, nil
+}
+
+// the role names and permissions a set of roles grants, skipping roles that
+// require mfa when it wasn't used
+func grantedBy(roles []*models.Role, mfa bool) ([]string, []string) {
+	names := []string{}
+	permissions := []string{}
+
+	// a permission can be granted by more than one role so only add it once
+	seen := make(map[string]bool)
+	for _, role := range roles {
+		if role.MFARequired && !mfa {
+			continue
+		}
+
+		names = append(names, role.Role)
+		for _, p := range role.Permissions {
+			if !seen[p] {
+				seen[p] = true
+				permissions = append(permissions, p)
 			}
+		}
+	}
+
+	return names, permissions
+}
+
+func (app *application) refreshToken(w http.ResponseWriter, r *http.Request) {
+	// look for our own cookie among the ones sent back by the user
+	cookie, err := r.Cookie(app.auth.CookieName)
+	if err != nil {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	// parse the token to get the claims
+	claims, err := app.auth.ParseRefreshToken(cookie.Value)
+	if err != nil {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	// the token has to be one we issued and still know about
+	stored, err := app.DB.GetRefreshToken(claims.ID)
+	if err != nil || stored.RevokedAt.Valid {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	// a token that was already rotated is being replayed, so assume it was
+	// stolen and kill the whole session it belongs to
+	if stored.UsedAt.Valid {
+		app.revokeReusedSession(r, stored)
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.DB.GetUSerById(stored.UserID)
+	if err != nil || user.Disabled {
+		app.errorJSON(w, errors.New("unknown user"), http.StatusUnauthorized)
+		return
+	}
+
+	// generate a new jwt user, reloading roles so changes take effect on refresh
+	u, err := app.jwtUserFor(user, claims.AMR)
+	if err != nil {
+		app.errorJSON(w, errors.New("unknown user"), http.StatusUnauthorized)
+		return
+	}
 
-			// set a new refresh cookie and send back to user
-			http.SetCookie(w, app.auth.GetRefreshCookie(tokenPairs.RefreshToken))
+	// generate new token pairs in the same session
+	tokenPairs, err := app.auth.GenerateTokens(&u, stored.SessionID)
+	if err != nil {
+		app.errorJSON(w, errors.New("error generating token"), http.StatusUnauthorized)
+		return
+	}
 
-			// write back to use
-			app.writeJSON(w, http.StatusOK, tokenPairs)
+	err = app.DB.RotateRefreshToken(stored.ID, models.RefreshToken{
+		ID:        tokenPairs.RefreshTokenID,
+		SessionID: stored.SessionID,
+		UserID:    user.ID,
+		ExpiresAt: tokenPairs.RefreshTokenExpiry,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		// someone else rotated this token first
+		if errors.Is(err, sql.ErrNoRows) {
+			app.revokeReusedSession(r, stored)
+			app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+			return
 		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
 	}
+
+	// set a new refresh cookie and send back to user
+	http.SetCookie(w, app.auth.GetRefreshCookie(tokenPairs.RefreshToken))
+
+	app.audit(r, auditEvent{
+		Action:   auditRefresh,
+		ActorID:  user.ID,
+		Entity:   "session",
+		EntityID: stored.SessionID,
+	})
+
+	// write back to use
+	app.writeJSON(w, http.StatusOK, tokenPairs)
+}
+
+func (app *application) revokeReusedSession(r *http.Request, token *models.RefreshToken) {
+	log.Printf("refresh token %s reused, revoking session %s for user %d", token.ID, token.SessionID, token.UserID)
+
+	err := app.DB.RevokeSession(token.SessionID)
+	if err != nil {
+		log.Println(err)
+	}
+
+	app.audit(r, auditEvent{
+		Action:   auditRefreshReuse,
+		ActorID:  token.UserID,
+		Entity:   "session",
+		EntityID: token.SessionID,
+	})
 }
 
 // create a log out route
 func (app *application) logOut(w http.ResponseWriter, r *http.Request) {
+	// revoke the session behind the refresh cookie so its tokens can't be used again
+	cookie, err := r.Cookie(app.auth.CookieName)
+	if err == nil {
+		claims, err := app.auth.ParseRefreshToken(cookie.Value)
+		if err == nil {
+			err = app.DB.RevokeSession(claims.SessionID)
+			if err != nil {
+				app.errorJSON(w, err, http.StatusInternalServerError)
+				return
+			}
+
+			userID, _ := strconv.Atoi(claims.Subject)
+			app.audit(r, auditEvent{
+				Action:   auditLogout,
+				ActorID:  userID,
+				Entity:   "session",
+				EntityID: claims.SessionID,
+			})
+		}
+	}
+
 	http.SetCookie(w, app.auth.GetExpiredRefreshCookie())
 
 	w.WriteHeader(http.StatusAccepted)
 }
 
 func (app *application) MovieCatalogue(w http.ResponseWriter, r *http.Request) {
-	movies, err := app.DB.AllMovies()
-	if err != nil {
-		app.errorJSON(w, err)
-		return
-	}
-	_ = app.writeJSON(w, http.StatusOK, movies)
+	app.listMovies(w, r)
 }
 
 func (app *application) GetOneMovie(w http.ResponseWriter, r *http.Request) {
@@ -168,6 +355,9 @@ func (app *application) GetOneMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// views are what makes a movie popular in the suggestions
+	app.suggest.Bump(suggestMovie, movie.ID, 1)
+
 	_ = app.writeJSON(w, http.StatusOK, movie)
 }
 
@@ -209,6 +399,11 @@ func (app *application) AllGenres(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) InsertMovie(w http.ResponseWriter, r *http.Request) {
+	principal, ok := app.principal(w, r)
+	if !ok {
+		return
+	}
+
 	var movie models.Movie
 	err := app.readJSON(w, r, &movie)
 	if err != nil {
@@ -220,6 +415,8 @@ func (app *application) InsertMovie(w http.ResponseWriter, r *http.Request) {
 	movie = app.getPoster(movie)
 	movie.CreatedAt = time.Now()
 	movie.UpdatedAt = time.Now()
+	movie.CreatedBy = principal.UserID
+	movie.UpdatedBy = principal.UserID
 
 	// insert the movie before handling the payload
 	newMovieId, err := app.DB.InsertMovie(movie)
@@ -228,16 +425,27 @@ func (app *application) InsertMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	movie.ID = newMovieId
+
 	// handle genres
-	err = app.DB.UpdateMovieGenre(newMovieId, movie.GenresArray)
+	err = app.updateMovieGenres(r, movie.ID, nil, movie.GenresArray)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
+	// read it back so the audit log has the genres as saved
+	saved, err := app.DB.GetOneMovie(movie.ID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.movieChanged(r, nil, saved)
+
 	resp := JSONResponse{
 		Error:   false,
-		Message: "Movie updated",
+		Message: "Movie inserted",
 	}
 
 	app.writeJSON(w, http.StatusAccepted, resp)
@@ -298,32 +506,75 @@ func (app *application) getPoster(movie models.Movie) models.Movie {
 }
 
 func (app *application) UpdateMovie(w http.ResponseWriter, r *http.Request) {
+	principal, ok := app.principal(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
 	var payload models.Movie
-	err := app.readJSON(w, r, payload)
+	err = app.readJSON(w, r, &payload)
 	if err != nil {
 		app.errorJSON(w, err)
+		return
 	}
 
-	// get the movie from db with the payload id
-	movie, err := app.DB.GetOneMovie(payload.ID)
+	// get the movie from db with the id in the url
+	movie, err := app.DB.GetOneMovie(id)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("movie not found"), http.StatusNotFound)
+			return
+		}
 		app.errorJSON(w, err)
+		return
+	}
+
+	// editors can only change the movies they added themselves
+	if movie.CreatedBy != principal.UserID && !principal.HasPermission(models.PermMoviesWriteAny) {
+		app.errorJSON(w, errors.New("you can only edit movies you created"), http.StatusForbidden)
+		return
 	}
 
+	before := *movie
+
 	// update the movie gotten from db with the neccessary payload
 	movie.Title = payload.Title
 	movie.Description = payload.Description
 	movie.ReleaseDate = payload.ReleaseDate
 	movie.RunTime = payload.RunTime
 	movie.MPAARating = payload.MPAARating
-	movie.UpdatedAt = payload.UpdatedAt
+	movie.UpdatedAt = time.Now()
+	movie.UpdatedBy = principal.UserID
+
+	err = app.DB.UpdateMovie(*movie)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
 
 	// update the movie genre
-	err = app.DB.UpdateMovieGenre(movie.ID, payload.GenresArray)
+	err = app.updateMovieGenres(r, movie.ID, movie.Genres, payload.GenresArray)
 	if err != nil {
 		app.errorJSON(w, err)
+		return
 	}
 
+	// the genres movie was loaded with have just been replaced, read it back
+	// so the audit log has the ones saved
+	after, err := app.DB.GetOneMovie(movie.ID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.movieChanged(r, &before, after)
+
 	// create a response object to return to the user
 	resp := JSONResponse{
 		Error:   false,
@@ -338,13 +589,32 @@ func (app *application) DeleteMovie(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
 		app.errorJSON(w, err)
+		return
+	}
+
+	// keep what the movie looked like for the audit log
+	movie, err := app.DB.GetOneMovie(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("movie not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
 	}
 
 	err = app.DB.DeleteMovie(id)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("movie not found"), http.StatusNotFound)
+			return
+		}
 		app.errorJSON(w, err)
+		return
 	}
 
+	app.movieChanged(r, movie, nil)
+
 	resp := JSONResponse{
 		Error:   false,
 		Message: "movie deleted succesfully",
@@ -354,6 +624,64 @@ func (app *application) DeleteMovie(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// keep everything that follows the catalogue up to date after a movie was
+// created, updated or deleted. before is nil for a new movie and after is nil
+// for a deleted one. Genres have to be saved first so subscribers see them
+func (app *application) movieChanged(r *http.Request, before, after *models.Movie) {
+	switch {
+	case before == nil:
+		app.suggestMovie(after)
+		app.audit(r, auditEvent{Action: auditMovieCreate, Entity: "movie", EntityID: after.ID, After: after})
+		app.events.Publish(events.Event{Type: events.MovieCreated, Payload: withoutGenres(after)})
+	case after == nil:
+		app.suggest.Remove(suggestMovie, before.ID)
+		app.audit(r, auditEvent{Action: auditMovieDelete, Entity: "movie", EntityID: before.ID, Before: before})
+		app.events.Publish(events.Event{Type: events.MovieDeleted, Payload: before})
+	default:
+		app.suggestMovie(after)
+		app.audit(r, auditEvent{Action: auditMovieUpdate, Entity: "movie", EntityID: after.ID, Before: before, After: after})
+		app.events.Publish(events.Event{Type: events.MovieUpdated, Payload: withoutGenres(after)})
+	}
+}
+
+// a copy of the movie for subscribers, the genres it was loaded with may have
+// been replaced since so they are looked up again when asked for
+func withoutGenres(movie *models.Movie) *models.Movie {
+	m := *movie
+	m.Genres = nil
+	m.GenresArray = nil
+	return &m
+}
+
+// replace a movie's genres and audit the change
+func (app *application) updateMovieGenres(r *http.Request, movieID int, current []*models.Genre, genreIDs []int) error {
+	err := app.DB.UpdateMovieGenre(movieID, genreIDs)
+	if err != nil {
+		return err
+	}
+
+	app.auditMovieGenres(r, movieID, current, genreIDs)
+	return nil
+}
+
+func (app *application) auditMovieGenres(r *http.Request, movieID int, current []*models.Genre, genreIDs []int) {
+	before := []int{}
+	for _, g := range current {
+		before = append(before, g.ID)
+	}
+	if genreIDs == nil {
+		genreIDs = []int{}
+	}
+
+	app.audit(r, auditEvent{
+		Action:   auditMovieGenres,
+		Entity:   "movie",
+		EntityID: movieID,
+		Before:   map[string][]int{"genres": before},
+		After:    map[string][]int{"genres": genreIDs},
+	})
+}
+
 func (app *application) AllMoviesByGenre(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/toluhikay/go-react/internal/models"
+)
+
+const impersonationExpiry = time.Minute * 10
+
+// issue a read only token that lets an admin see the api exactly as the user
+// does. The token carries the admin in its act claim
+func (app *application) impersonateUser(w http.ResponseWriter, r *http.Request) {
+	principal, ok := app.principal(w, r)
+	if !ok {
+		return
+	}
+
+	if principal.APIKey() {
+		app.errorJSON(w, errors.New("impersonation needs a logged in session"), http.StatusForbidden)
+		return
+	}
+
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if user.ID == principal.UserID {
+		app.errorJSON(w, errors.New("you can't impersonate yourself"), http.StatusConflict)
+		return
+	}
+	if user.Disabled {
+		app.errorJSON(w, errors.New("account is disabled"), http.StatusConflict)
+		return
+	}
+
+	roles, err := app.DB.GetUserRoles(user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// give the roles the user would have after their own login
+	u := jwtUSer{
+		ID:        user.ID,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+	}
+	u.Roles, u.Permissions = grantedBy(roles, user.TOTPEnabled)
+
+	actor := Actor{
+		Subject: fmt.Sprint(principal.UserID),
+		Name:    principal.Name,
+	}
+
+	token, expiry, err := app.auth.GenerateImpersonationToken(&u, actor, impersonationExpiry)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("user %d started impersonating user %d", principal.UserID, user.ID)
+	app.audit(r, auditEvent{
+		Action:   auditImpersonate,
+		Entity:   "user",
+		EntityID: user.ID,
+		After:    map[string]interface{}{"expires_at": expiry},
+	})
+
+	var payload = struct {
+		Token     string       `json:"token"`
+		ExpiresAt time.Time    `json:"expires_at"`
+		User      *models.User `json:"user"`
+	}{
+		Token:     token,
+		ExpiresAt: expiry,
+		User:      user,
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
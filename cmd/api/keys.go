@@ -0,0 +1,521 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const rsaKeyBits = 2048
+
+const (
+	// the lock instances sharing a key dir take to rotate, so only one of them
+	// generates the next key
+	keyLockFile = "rotate.lock"
+	keyLockWait = time.Second * 45
+	keyLockPoll = time.Millisecond * 100
+	// a lock this old was left by an instance that died while rotating
+	keyLockStale = time.Second * 30
+	// how often a token with an unknown kid can make the dir be read again
+	keyReloadEvery = time.Second * 10
+)
+
+// a private key tokens are signed with, identified by the kid header
+type signingKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	Private   crypto.Signer
+	CreatedAt time.Time
+	// zero while the key is active, otherwise when it stops verifying tokens
+	RetireAt time.Time
+}
+
+// the keys used to sign and verify tokens. The newest key signs, older keys
+// keep verifying until their overlap runs out so tokens already handed out
+// stay valid through a rotation
+type KeySet struct {
+	Algorithm   string
+	Dir         string
+	RotateEvery time.Duration
+	Overlap     time.Duration
+
+	mu   sync.RWMutex
+	keys []*signingKey
+	// when the keys were last read from Dir
+	loadedAt time.Time
+}
+
+// load the keys from dir, or generate one when there are none. An empty dir
+// keeps the keys in memory only, so they are lost on restart. Instances
+// sharing dir share the keys: whichever finds the newest key overdue first
+// generates the next one and the rest read it from there
+func NewKeySet(algorithm, dir string, rotateEvery, overlap time.Duration) (*KeySet, error) {
+	k := &KeySet{
+		Algorithm:   algorithm,
+		Dir:         dir,
+		RotateEvery: rotateEvery,
+		Overlap:     overlap,
+	}
+
+	if _, err := k.signingMethod(); err != nil {
+		return nil, err
+	}
+
+	// rotate straight away if there is no key yet or the newest one is overdue
+	err := k.rotateIfDue()
+	if err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+func (k *KeySet) signingMethod() (jwt.SigningMethod, error) {
+	switch k.Algorithm {
+	case "RS256":
+		return jwt.SigningMethodRS256, nil
+	case "EdDSA":
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", k.Algorithm)
+	}
+}
+
+// whether there is no key to sign with yet or the newest one is overdue
+func (k *KeySet) due() bool {
+	active := k.active()
+	return active == nil || (k.RotateEvery > 0 && time.Since(active.CreatedAt) >= k.RotateEvery)
+}
+
+// rotate if due. With a dir the keys on disk are read first, since another
+// instance may have rotated already, and only the instance holding the lock
+// rotates
+func (k *KeySet) rotateIfDue() error {
+	if k.Dir == "" {
+		if !k.due() {
+			k.prune()
+			return nil
+		}
+		return k.Rotate()
+	}
+
+	err := k.load()
+	if err != nil {
+		return err
+	}
+	if !k.due() {
+		return nil
+	}
+
+	unlock, err := k.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// whoever held the lock before may have just rotated
+	err = k.load()
+	if err != nil {
+		return err
+	}
+	if !k.due() {
+		return nil
+	}
+	return k.Rotate()
+}
+
+// take the rotation lock in dir, waiting for another instance to finish with
+// it. The returned func releases it
+func (k *KeySet) lock() (func(), error) {
+	err := os.MkdirAll(k.Dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(k.Dir, keyLockFile)
+	deadline := time.Now().Add(keyLockWait)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		info, err := os.Stat(path)
+		if err == nil && time.Since(info.ModTime()) > keyLockStale {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New("timed out waiting for the signing key lock")
+		}
+		time.Sleep(keyLockPoll)
+	}
+}
+
+func (k *KeySet) active() *signingKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if len(k.keys) == 0 {
+		return nil
+	}
+	return k.keys[len(k.keys)-1]
+}
+
+// generate a new signing key and start the overlap for the current one
+func (k *KeySet) Rotate() error {
+	method, err := k.signingMethod()
+	if err != nil {
+		return err
+	}
+
+	var private crypto.Signer
+	switch method {
+	case jwt.SigningMethodRS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	default:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return err
+	}
+
+	key := &signingKey{
+		Method:    method,
+		Private:   private,
+		CreatedAt: time.Now(),
+	}
+
+	key.ID, err = keyID(private.Public())
+	if err != nil {
+		return err
+	}
+
+	if k.Dir != "" {
+		err = k.save(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	k.mu.Lock()
+	if len(k.keys) > 0 {
+		k.keys[len(k.keys)-1].RetireAt = key.CreatedAt.Add(k.Overlap)
+	}
+	k.keys = append(k.keys, key)
+	k.mu.Unlock()
+
+	k.prune()
+
+	log.Printf("rotated signing key, now signing with %s", key.ID)
+	return nil
+}
+
+// drop keys whose overlap has run out
+func (k *KeySet) prune() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	var live []*signingKey
+	for _, key := range k.keys {
+		if !key.RetireAt.IsZero() && time.Now().After(key.RetireAt) {
+			if k.Dir != "" {
+				err := os.Remove(filepath.Join(k.Dir, key.ID+".pem"))
+				if err != nil && !errors.Is(err, os.ErrNotExist) {
+					log.Println(err)
+				}
+			}
+			continue
+		}
+		live = append(live, key)
+	}
+	k.keys = live
+}
+
+// rotate on schedule until stop is closed, picking up the keys other
+// instances sharing the dir have generated on every tick
+func (k *KeySet) RunRotation(stop <-chan struct{}) {
+	if k.RotateEvery <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			err := k.rotateIfDue()
+			if err != nil {
+				log.Println("rotating signing key:", err)
+			}
+		}
+	}
+}
+
+// sign the claims with the active key
+func (k *KeySet) Sign(claims jwt.Claims) (string, error) {
+	key := k.active()
+	if key == nil {
+		return "", errors.New("no signing key available")
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(key.Private)
+}
+
+// pick the public key to verify a token with by its kid, for jwt.ParseWithClaims
+func (k *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("token has no kid")
+	}
+
+	key := k.find(kid)
+	// another instance may have rotated since the dir was last read. Made up
+	// kids can't make it be read more than every keyReloadEvery
+	if key == nil && k.Dir != "" && k.reloadDue() {
+		err := k.load()
+		if err != nil {
+			log.Println("reading signing keys:", err)
+		}
+		key = k.find(kid)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	// make sure nobody swapped the algorithm on us
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+	return key.Private.Public(), nil
+}
+
+// the key with the id that can still verify tokens, nil if there isn't one
+func (k *KeySet) find(kid string) *signingKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	for _, key := range k.keys {
+		if key.ID != kid {
+			continue
+		}
+		if !key.RetireAt.IsZero() && time.Now().After(key.RetireAt) {
+			return nil
+		}
+		return key
+	}
+	return nil
+}
+
+func (k *KeySet) reloadDue() bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return time.Since(k.loadedAt) >= keyReloadEvery
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// the public half of every key still able to verify tokens
+func (k *KeySet) JWKS() []jsonWebKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	keys := []jsonWebKey{}
+	for _, key := range k.keys {
+		if !key.RetireAt.IsZero() && time.Now().After(key.RetireAt) {
+			continue
+		}
+
+		jwk := jsonWebKey{
+			Use: "sig",
+			Alg: key.Method.Alg(),
+			Kid: key.ID,
+		}
+
+		switch public := key.Private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+
+		keys = append(keys, jwk)
+	}
+
+	return keys
+}
+
+// derive the kid from the public key so the same key always gets the same id
+func keyID(public crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:16]), nil
+}
+
+// write a key to dir as <kid>.pem, its age comes from the file's mod time. It
+// is written under another name first so other instances never read half a key
+func (k *KeySet) save(key *signingKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key.Private)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(k.Dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(k.Dir, key.ID+".pem")
+	err = os.WriteFile(path+".tmp", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// read every key in dir, oldest first, and work out how long each one has left
+func (k *KeySet) load() error {
+	paths, err := filepath.Glob(filepath.Join(k.Dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	method, err := k.signingMethod()
+	if err != nil {
+		return err
+	}
+
+	var keys []*signingKey
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return fmt.Errorf("%s: no pem data", path)
+		}
+
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		private, ok := parsed.(crypto.Signer)
+		if !ok {
+			return fmt.Errorf("%s: unsupported key type", path)
+		}
+
+		// skip keys left over from a different algorithm
+		switch private.(type) {
+		case *rsa.PrivateKey:
+			if method != jwt.SigningMethodRS256 {
+				continue
+			}
+		case ed25519.PrivateKey:
+			if method != jwt.SigningMethodEdDSA {
+				continue
+			}
+		default:
+			continue
+		}
+
+		id, err := keyID(private.Public())
+		if err != nil {
+			return err
+		}
+
+		keys = append(keys, &signingKey{
+			ID:        strings.TrimSuffix(filepath.Base(path), ".pem"),
+			Method:    method,
+			Private:   private,
+			CreatedAt: info.ModTime(),
+		})
+
+		if keys[len(keys)-1].ID != id {
+			return fmt.Errorf("%s: file name does not match key id %s", path, id)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+
+	// a key retires once the overlap after its successor was created is over
+	for i := 0; i < len(keys)-1; i++ {
+		keys[i].RetireAt = keys[i+1].CreatedAt.Add(k.Overlap)
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.loadedAt = time.Now()
+	k.mu.Unlock()
+
+	k.prune()
+	return nil
+}
+
+func (app *application) jwks(w http.ResponseWriter, r *http.Request) {
+	var payload = struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{
+		Keys: app.auth.Keys.JWKS(),
+	}
+
+	headers := http.Header{}
+	headers.Set("Cache-Control", "public, max-age=300")
+
+	_ = app.writeJSON(w, http.StatusOK, payload, headers)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestKeySetRotateRetiresThePreviousKey(t *testing.T) {
+	for _, alg := range []string{"RS256", "EdDSA"} {
+		t.Run(alg, func(t *testing.T) {
+			keys, err := NewKeySet(alg, "", time.Hour, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			old := keys.active()
+			signed, err := keys.Sign(jwt.RegisteredClaims{Subject: "1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = keys.Rotate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if keys.active().ID == old.ID {
+				t.Fatal("rotating kept the same signing key")
+			}
+
+			// the old key keeps verifying through the overlap and is still published
+			if _, err := jwt.Parse(signed, keys.Keyfunc); err != nil {
+				t.Errorf("token signed before the rotation: %v", err)
+			}
+			if n := len(keys.JWKS()); n != 2 {
+				t.Errorf("JWKS has %d keys during the overlap, want 2", n)
+			}
+
+			// once the overlap is over it stops verifying and is dropped
+			old.RetireAt = time.Now().Add(-time.Second)
+			if _, err := jwt.Parse(signed, keys.Keyfunc); err == nil {
+				t.Error("token signed by a retired key verified")
+			}
+			keys.prune()
+			if n := len(keys.JWKS()); n != 1 {
+				t.Errorf("JWKS has %d keys after the overlap, want 1", n)
+			}
+		})
+	}
+}
+
+func TestKeySetKeyfunc(t *testing.T) {
+	keys, err := NewKeySet("EdDSA", "", 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid := keys.active().ID
+
+	tests := []struct {
+		name   string
+		method jwt.SigningMethod
+		kid    interface{}
+		ok     bool
+	}{
+		{"matching key", jwt.SigningMethodEdDSA, kid, true},
+		{"algorithm swapped", jwt.SigningMethodHS256, kid, false},
+		{"unknown kid", jwt.SigningMethodEdDSA, "nope", false},
+		{"no kid", jwt.SigningMethodEdDSA, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := jwt.New(tt.method)
+			if tt.kid != nil {
+				token.Header["kid"] = tt.kid
+			}
+
+			_, err := keys.Keyfunc(token)
+			if tt.ok && err != nil {
+				t.Errorf("Keyfunc: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("Keyfunc accepted the token")
+			}
+		})
+	}
+}
+
+func TestKeySetLoadsWhatItSaved(t *testing.T) {
+	dir := t.TempDir()
+
+	first, err := NewKeySet("RS256", dir, time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := first.Sign(jwt.RegisteredClaims{Subject: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a restart reads the same key back rather than generating another
+	second, err := NewKeySet("RS256", dir, time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.active().ID != first.active().ID {
+		t.Errorf("reloaded key %s, want %s", second.active().ID, first.active().ID)
+	}
+	if _, err := jwt.Parse(signed, second.Keyfunc); err != nil {
+		t.Errorf("token signed before the restart: %v", err)
+	}
+
+	// keys left over from another algorithm are ignored
+	other, err := NewKeySet("EdDSA", dir, time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.active().ID == first.active().ID {
+		t.Error("an RS256 key was loaded for EdDSA")
+	}
+}
+
+func TestKeySetSharedDir(t *testing.T) {
+	dir := t.TempDir()
+
+	a, err := NewKeySet("EdDSA", dir, time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewKeySet("EdDSA", dir, time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.active().ID != b.active().ID {
+		t.Fatal("instances sharing a dir started with different keys")
+	}
+
+	err = a.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := a.Sign(jwt.RegisteredClaims{Subject: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// b reads the dir again for the kid it hasn't seen yet
+	b.loadedAt = time.Time{}
+	if _, err := jwt.Parse(signed, b.Keyfunc); err != nil {
+		t.Errorf("token signed by the other instance's new key: %v", err)
+	}
+
+	// b finds the new key isn't due for rotation and leaves it alone
+	err = b.rotateIfDue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if len(paths) != 2 {
+		t.Errorf("%d keys in the dir, want 2", len(paths))
+	}
+	if _, err := os.Stat(filepath.Join(dir, keyLockFile)); !os.IsNotExist(err) {
+		t.Error("the rotation lock was left behind")
+	}
+}
@@ -5,10 +5,17 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/toluhikay/go-react/internal/events"
+	"github.com/toluhikay/go-react/internal/graph"
+	"github.com/toluhikay/go-react/internal/mailer"
+	"github.com/toluhikay/go-react/internal/oidc"
 	"github.com/toluhikay/go-react/internal/repository"
 	dbrepo "github.com/toluhikay/go-react/internal/repository/dbRepo"
+	"github.com/toluhikay/go-react/internal/suggest"
+	"github.com/toluhikay/go-react/internal/throttle"
 )
 
 type application struct {
@@ -16,11 +23,42 @@ type application struct {
 	Domain       string
 	DB           repository.DatabaseRepo
 	auth         Auth
-	JWTSecret    string
 	JWTIssuer    string
 	JWTAudience  string
 	CookieDomain string
 	APIKey       string
+	JWTKeys      struct {
+		Algorithm   string
+		Dir         string
+		RotateEvery time.Duration
+		Overlap     time.Duration
+	}
+	BaseURL string
+	// the frontend page reset links open, it posts the token to /password/reset
+	PasswordResetURL string
+	LoginThrottle    string
+	OIDC             oidc.Config
+	oidc             *oidc.Provider
+	accountLimiter   *throttle.Limiter
+	ipLimiter        *throttle.Limiter
+	mailer           mailer.Mailer
+	suggest          *suggest.Index
+	graph            *graph.Graph
+	events           *events.Bus
+	GraphLimits      graph.Limits
+	GraphPersisted   struct {
+		Max      int
+		Manifest string
+	}
+	Mail struct {
+		Driver   string
+		LogFile  string
+		Host     string
+		Port     int
+		Username string
+		Password string
+		Sender   string
+	}
 }
 
 func main() {
@@ -28,29 +66,179 @@ func main() {
 
 	// read from comand line using the flag package, second arg is what i want the flag to be on the cmd line
 	flag.StringVar(&app.DSN, "dsn", "host=localhost port=5433 user=postgres password=postgres dbname=movies sslmode=disable timezone=UTC+1 connect_timeout=5", "Postgres connection string")
-	flag.StringVar(&app.JWTSecret, "jwt-secret", "example.com", "signing secret")
+	flag.StringVar(&app.JWTKeys.Algorithm, "jwt-alg", "RS256", "token signing algorithm (RS256|EdDSA)")
+	flag.StringVar(&app.JWTKeys.Dir, "jwt-key-dir", "", "directory signing keys are kept in, shared by every instance. Required unless -jwt-key-rotation is 0, empty keeps them in memory")
+	flag.DurationVar(&app.JWTKeys.RotateEvery, "jwt-key-rotation", time.Hour*24*30, "how often to rotate the signing key, 0 disables rotation")
+	flag.DurationVar(&app.JWTKeys.Overlap, "jwt-key-overlap", time.Hour*48, "how long a rotated key keeps verifying tokens, must outlive the refresh token")
 	flag.StringVar(&app.JWTIssuer, "jwt-issuer", "example.com", "signing issuer")
 	flag.StringVar(&app.JWTAudience, "jwt-audience", "example.com", "signing audience")
 	flag.StringVar(&app.CookieDomain, "cookie-domain", "localhost", "cookie domain")
 	flag.StringVar(&app.Domain, "domain", "example.com", "domain")
 	flag.StringVar(&app.APIKey, "api-key", "4afe5bb347ffcc8555b9646caac7b88d", "api key")
+	flag.StringVar(&app.BaseURL, "base-url", "http://localhost:4000", "public url used to build links sent by email")
+	flag.StringVar(&app.PasswordResetURL, "password-reset-url", "http://localhost:3000/reset-password", "frontend page password reset links open, the token is added as ?token=")
+	flag.StringVar(&app.Mail.Driver, "mailer", "log", "how to deliver mail (smtp|log)")
+	flag.StringVar(&app.Mail.LogFile, "mail-log-file", "", "file the log mailer writes to, empty writes to the log")
+	flag.StringVar(&app.Mail.Host, "smtp-host", "localhost", "smtp host")
+	flag.IntVar(&app.Mail.Port, "smtp-port", 1025, "smtp port")
+	flag.StringVar(&app.Mail.Username, "smtp-username", "", "smtp username")
+	flag.StringVar(&app.Mail.Password, "smtp-password", "", "smtp password")
+	flag.StringVar(&app.Mail.Sender, "mail-sender", "Go React <no-reply@example.com>", "address mail is sent from")
+	flag.StringVar(&app.LoginThrottle, "login-throttle", "memory", "where failed logins are tracked (memory|postgres)")
+	flag.StringVar(&app.OIDC.Issuer, "oidc-issuer", "", "openid connect issuer url, empty disables single sign-on")
+	flag.StringVar(&app.OIDC.ClientID, "oidc-client-id", "", "openid connect client id")
+	flag.StringVar(&app.OIDC.ClientSecret, "oidc-client-secret", "", "openid connect client secret, empty for public clients")
+	flag.StringVar(&app.OIDC.RedirectURL, "oidc-redirect-url", "", "openid connect redirect url, defaults to <base-url>/auth/oidc/callback")
+	oidcScopes := flag.String("oidc-scopes", "openid email profile", "openid connect scopes to request")
+	flag.IntVar(&app.GraphLimits.MaxDepth, "graph-max-depth", graph.DefaultLimits.MaxDepth, "deepest graphql query allowed, 0 for no limit")
+	flag.IntVar(&app.GraphLimits.MaxComplexity, "graph-max-complexity", graph.DefaultLimits.MaxComplexity, "most complex graphql query allowed, 0 for no limit")
+	flag.IntVar(&app.GraphLimits.ListSize, "graph-list-size", graph.DefaultLimits.ListSize, "items assumed for graphql lists without a limit when working out complexity")
+	flag.DurationVar(&app.GraphLimits.Timeout, "graph-timeout", graph.DefaultLimits.Timeout, "how long a graphql query can run, 0 for no limit")
+	flag.IntVar(&app.GraphPersisted.Max, "graph-persisted-queries", 1000, "how many automatic persisted queries to keep, 0 turns them off")
+	flag.StringVar(&app.GraphPersisted.Manifest, "graph-manifest", "", "persisted query manifest, when set only the queries in it can run")
+	graphCosts := flag.String("graph-costs", "Query.search=10", "graphql field costs as Type.field=cost, comma separated")
+	autoMigrate := flag.Bool("migrate", false, "apply pending database migrations before starting")
 	flag.Parse()
 
+	// the migrate subcommand manages the schema and exits
+	if flag.Arg(0) == "migrate" {
+		err := app.migrateCommand(flag.Args()[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if app.OIDC.Issuer != "" {
+		if app.OIDC.RedirectURL == "" {
+			app.OIDC.RedirectURL = app.BaseURL + "/auth/oidc/callback"
+		}
+		app.OIDC.Scopes = strings.Fields(*oidcScopes)
+		app.oidc = oidc.NewProvider(app.OIDC)
+	}
+
+	// pick the mailer, smtp for real delivery or the log for local development
+	switch app.Mail.Driver {
+	case "smtp":
+		app.mailer = &mailer.SMTPMailer{
+			Host:     app.Mail.Host,
+			Port:     app.Mail.Port,
+			Username: app.Mail.Username,
+			Password: app.Mail.Password,
+			Sender:   app.Mail.Sender,
+		}
+	case "log":
+		app.mailer = &mailer.LogMailer{Path: app.Mail.LogFile}
+	default:
+		log.Fatalf("unknown mailer %q", app.Mail.Driver)
+	}
+
 	// connect to db using pgx v4
 	conn, err := app.connectToDb()
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if *autoMigrate {
+		err = migrateUp(conn)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	app.DB = &dbrepo.PostgresDbRepo{DB: conn}
 
+	// track failed logins in memory for a single instance, or postgres to share lockouts
+	var attempts throttle.Store
+	switch app.LoginThrottle {
+	case "memory":
+		attempts = throttle.NewMemoryStore()
+	case "postgres":
+		attempts = app.DB
+	default:
+		log.Fatalf("unknown login throttle store %q", app.LoginThrottle)
+	}
+
+	app.accountLimiter = &throttle.Limiter{
+		Store: attempts,
+		Policy: throttle.Policy{
+			Threshold:   5,
+			BaseLockout: time.Minute,
+			MaxLockout:  time.Hour,
+			Window:      time.Hour,
+		},
+	}
+
+	// an ip can front many users, so it gets more room before it is locked
+	app.ipLimiter = &throttle.Limiter{
+		Store: attempts,
+		Policy: throttle.Policy{
+			Threshold:   20,
+			BaseLockout: time.Minute,
+			MaxLockout:  time.Hour,
+			Window:      time.Hour,
+		},
+	}
+
 	// defer conn.Close() -> one way to close conn another is down
 	defer app.DB.Connection().Close()
 
+	// load the typeahead index, the movie handlers keep it current from here on
+	app.suggest = suggest.New(maxSuggestLimit)
+	err = app.loadSuggestions()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	app.events = events.New(64)
+
+	app.graph, err = graph.New(app.DB, app.events)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	app.GraphLimits.Costs, err = parseGraphCosts(*graphCosts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.graph.Limits = app.GraphLimits
+
+	// a manifest locks the graph down to the queries in it, otherwise clients
+	// register queries as they send them
+	switch {
+	case app.GraphPersisted.Manifest != "":
+		app.graph.Persisted, err = graph.LoadManifest(app.GraphPersisted.Manifest)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("graphql allowlist loaded with %d queries", app.graph.Persisted.Len())
+	case app.GraphPersisted.Max > 0:
+		app.graph.Persisted = graph.NewPersistedQueries(app.GraphPersisted.Max)
+	}
+
+	// in-memory keys are fine on a laptop but not in production, where every
+	// restart would sign everyone out and instances couldn't verify each other's
+	// tokens. Rotating them would split instances apart even if they started
+	// with the same keys, so that needs a dir
+	if app.JWTKeys.Dir == "" {
+		if app.JWTKeys.RotateEvery > 0 {
+			log.Fatal("-jwt-key-dir is required while -jwt-key-rotation is on, " +
+				"set -jwt-key-rotation 0 to run a single instance with keys in memory")
+		}
+		log.Println("WARNING: -jwt-key-dir is not set, signing keys are kept in memory only. " +
+			"Every restart signs everyone out and tokens from one instance fail on any other")
+	}
+
+	keys, err := NewKeySet(app.JWTKeys.Algorithm, app.JWTKeys.Dir, app.JWTKeys.RotateEvery, app.JWTKeys.Overlap)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go keys.RunRotation(nil)
+
 	app.auth = Auth{
 		Issuer:        app.JWTIssuer,
 		Audience:      app.JWTAudience,
-		Secret:        app.JWTSecret,
+		Keys:          keys,
 		TokenExpiry:   time.Minute * 15,
 		RefreshExpiry: time.Hour * 24,
 		CookiePath:    "/",
@@ -58,6 +246,11 @@ func main() {
 		CookieDomain:  app.CookieDomain,
 	}
 
+	// a key retired before the refresh tokens it signed expire would log everyone out
+	if app.JWTKeys.RotateEvery > 0 && app.JWTKeys.Overlap < app.auth.RefreshExpiry {
+		log.Fatalf("-jwt-key-overlap must be at least %s", app.auth.RefreshExpiry)
+	}
+
 	fmt.Println("Listening on port 4000")
 	log.Fatal(http.ListenAndServe(":"+"4000", app.routes()))
 }
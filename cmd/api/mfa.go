@@ -0,0 +1,234 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/toluhikay/go-react/internal/models"
+	"github.com/toluhikay/go-react/internal/totp"
+)
+
+const (
+	mfaChallengeExpiry = time.Minute * 5
+	recoveryCodeCount  = 10
+)
+
+// create a set of one-off recovery codes, formatted like abcde-fghij
+func generateRecoveryCodes() ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+	}
+	return codes, nil
+}
+
+// hash a recovery code the way it was typed, ignoring case, spaces and dashes
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return hashToken(code)
+}
+
+// start totp enrollment, handing back the secret for the authenticator app
+func (app *application) enrollTOTP(w http.ResponseWriter, r *http.Request) {
+	principal, ok := app.principal(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := app.DB.GetUSerById(principal.UserID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// the secret only takes effect once a code from it has been confirmed
+	err = app.DB.SetUserTOTPSecret(user.ID, secret)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("two-factor authentication is already enabled"), http.StatusConflict)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	var payload = struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}{
+		Secret:     secret,
+		OTPAuthURI: totp.URI(app.auth.Issuer, user.Email, secret),
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// finish enrollment with a code from the app and hand out the recovery codes
+func (app *application) confirmTOTP(w http.ResponseWriter, r *http.Request) {
+	principal, ok := app.principal(w, r)
+	if !ok {
+		return
+	}
+
+	var reqpayload struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &reqpayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	user, err := app.DB.GetUSerById(principal.UserID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if user.TOTPEnabled {
+		app.errorJSON(w, errors.New("two-factor authentication is already enabled"), http.StatusConflict)
+		return
+	}
+	if user.TOTPSecret == "" {
+		app.errorJSON(w, errors.New("start enrollment first"))
+		return
+	}
+
+	step, ok := totp.Verify(user.TOTPSecret, reqpayload.Code, time.Now())
+	if !ok {
+		app.errorJSON(w, errors.New("invalid code"))
+		return
+	}
+
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	hashes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	err = app.DB.EnableUserTOTP(user.ID, step, hashes)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// recovery codes are only ever shown this once
+	var payload = struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{
+		RecoveryCodes: codes,
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// second login step, swap the mfa challenge and a code for the real tokens
+// answer a first login step with a challenge for the second. purpose says what
+// the first step was, so the tokens issued after the second record it
+func (app *application) mfaChallenge(w http.ResponseWriter, user *models.User, purpose string) {
+	challenge, err := app.auth.GeneratePurposeToken(purpose, user.ID, user.Email, mfaChallengeExpiry)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var payload = struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+	}{
+		MFARequired: true,
+		MFAToken:    challenge,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+func (app *application) authenticateMFA(w http.ResponseWriter, r *http.Request) {
+	var reqpayload struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &reqpayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	firstFactor := amrPassword
+	userID, _, err := app.auth.ParsePurposeToken(purposeMFAChallenge, reqpayload.MFAToken)
+	if err != nil {
+		firstFactor = amrFederated
+		userID, _, err = app.auth.ParsePurposeToken(purposeSSOMFAChallenge, reqpayload.MFAToken)
+	}
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid or expired mfa challenge"), http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.DB.GetUSerById(userID)
+	if err != nil || !user.TOTPEnabled {
+		app.errorJSON(w, errors.New("invalid or expired mfa challenge"), http.StatusUnauthorized)
+		return
+	}
+
+	// code guesses count towards the same lockout as password guesses
+	if app.loginLocked(w, r, user.Email) {
+		return
+	}
+
+	switch {
+	case reqpayload.Code != "":
+		step, ok := totp.Verify(user.TOTPSecret, reqpayload.Code, time.Now())
+		if !ok {
+			app.loginFailed(r, user.Email)
+			app.errorJSON(w, errors.New("invalid code"), http.StatusUnauthorized)
+			return
+		}
+
+		// a code that was already used to log in can't be used again
+		err = app.DB.UseTOTPStep(user.ID, step)
+	case reqpayload.RecoveryCode != "":
+		err = app.DB.UseRecoveryCode(user.ID, hashRecoveryCode(reqpayload.RecoveryCode))
+	default:
+		app.errorJSON(w, errors.New("a code or recovery code is required"))
+		return
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.loginFailed(r, user.Email)
+			app.errorJSON(w, errors.New("invalid code"), http.StatusUnauthorized)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.login(w, r, user, []string{firstFactor, amrOTP, amrMFA})
+}
@@ -1,14 +1,21 @@
 package main
 
-import "net/http"
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
 
 func (app *application) enableCORS(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "http://*")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		w.Header().Set("Access-Control-Expose-Headers", "Link")
 
 		if r.Method == "OPTIONS" {
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, PUT, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, PUT, DELETE, OPTIONS")
 			w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, X-CSRF-TOKEN, Authorization")
 			return
 		} else {
@@ -17,13 +24,174 @@ func (app *application) enableCORS(h http.Handler) http.Handler {
 	})
 }
 
+// authenticate a request by either a Bearer jwt or an ApiKey
+func (app *application) authenticateRequest(w http.ResponseWriter, r *http.Request) (*Claims, error) {
+	authHeader := r.Header.Get("Authorization")
+
+	if strings.HasPrefix(authHeader, tokenTypeAPIKey+" ") {
+		w.Header().Add("Vary", "Authorization")
+		return app.authenticateAPIKey(strings.TrimPrefix(authHeader, tokenTypeAPIKey+" "))
+	}
+
+	_, claims, err := app.auth.GetTokenFromHeaderAndVerify(w, r)
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, app.checkAccessToken(claims)
+}
+
+// a signed access token is only good while its session is live and its user
+// is enabled, so signing out, revoking sessions, resetting a password or
+// disabling the user take effect straight away rather than at expiry
+func (app *application) checkAccessToken(claims *Claims) error {
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return errors.New("invalid token subject")
+	}
+
+	allowed, err := app.DB.AccessAllowed(userID, claims.SessionID)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return errors.New("session has been revoked")
+	}
+
+	// an impersonation token stops working when the admin behind it is disabled
+	if claims.Actor != nil {
+		actorID, err := strconv.Atoi(claims.Actor.Subject)
+		if err != nil {
+			return errors.New("invalid token actor")
+		}
+		allowed, err = app.DB.AccessAllowed(actorID, "")
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return errors.New("session has been revoked")
+		}
+	}
+
+	return nil
+}
+
+// authenticate an Authorization value sent some other way than a header, like
+// in the first message on a websocket
+func (app *application) authenticateCredentials(authorization string) (*Principal, error) {
+	var claims *Claims
+	var err error
+
+	switch {
+	case strings.HasPrefix(authorization, tokenTypeAPIKey+" "):
+		claims, err = app.authenticateAPIKey(strings.TrimPrefix(authorization, tokenTypeAPIKey+" "))
+	case strings.HasPrefix(authorization, "Bearer "):
+		claims, err = app.auth.VerifyAccessToken(strings.TrimPrefix(authorization, "Bearer "))
+		if err == nil {
+			err = app.checkAccessToken(claims)
+		}
+	default:
+		err = errors.New("invalid authorization")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newPrincipal(claims)
+}
+
+// authenticate the request and put the principal in its context for the handlers
 func (app *application) authRequired(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, _, err := app.auth.GetTokenFromHeaderAndVerify(w, r)
+		principal, err := app.authenticatePrincipal(w, r)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		r = r.WithContext(contextWithPrincipal(r.Context(), principal))
+
+		// impersonation is for looking, not for changing things on the user's behalf
+		if principal.Impersonating() && !safeMethod(r.Method) {
+			log.Printf("blocked %s %s by user %d impersonating user %d", r.Method, r.URL.Path, principal.ActorID, principal.UserID)
+			app.audit(r, auditEvent{
+				Action: auditImpersonationBlocked,
+				After:  map[string]string{"method": r.Method, "path": r.URL.Path},
+			})
+			app.errorJSON(w, errors.New("changes can't be made while impersonating a user"), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// like authRequired but lets requests without credentials through, for routes
+// that serve both. The handler decides what needs a principal, so nothing is
+// blocked while impersonating here
+func (app *application) authOptional(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		principal, err := app.authenticatePrincipal(w, r)
 		if err != nil {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
+
+		next.ServeHTTP(w, r.WithContext(contextWithPrincipal(r.Context(), principal)))
+	})
+}
+
+func (app *application) authenticatePrincipal(w http.ResponseWriter, r *http.Request) (*Principal, error) {
+	claims, err := app.authenticateRequest(w, r)
+	if err != nil {
+		return nil, err
+	}
+	return newPrincipal(claims)
+}
+
+// refuse changes made with an api key, for the routes that manage the account
+// itself. A key is for automating the catalogue, one that leaks shouldn't be
+// able to change the email, enrol mfa or revoke the owner's sessions and keys.
+// This has to run after authRequired
+func (app *application) sessionRequired(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := app.principal(w, r)
+		if !ok {
+			return
+		}
+
+		if principal.APIKey() && !safeMethod(r.Method) {
+			app.errorJSON(w, errors.New("the account can only be changed from a logged in session"), http.StatusForbidden)
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+// make sure the principal holds a permission before letting the request
+// through, this has to run after authRequired
+func (app *application) requirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := app.principal(w, r)
+			if !ok {
+				return
+			}
+
+			if !principal.HasPermission(permission) {
+				app.errorJSON(w, errors.New("you do not have permission to perform this action"), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/toluhikay/go-react/internal/migrate"
+	"github.com/toluhikay/go-react/migrations"
+)
+
+const migrateUsage = `usage: api [flags] migrate <command>
+
+commands:
+  up                  apply every pending migration
+  down                revert the newest applied migration
+  to <version>        migrate up or down to version, 0 reverts everything
+  status              list the migrations and whether they are applied
+  baseline <version>  record migrations up to version as applied without
+                      running them, 2 for a database created from the
+                      original sql/create_tables.sql dump`
+
+// run `migrate <command>` from the command line
+func (app *application) migrateCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	version := 0
+	switch args[0] {
+	case "up", "down", "status":
+		if len(args) != 1 {
+			return errors.New(migrateUsage)
+		}
+	case "to", "baseline":
+		if len(args) != 2 {
+			return errors.New(migrateUsage)
+		}
+		v, err := strconv.Atoi(args[1])
+		if err != nil || v < 0 {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		version = v
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	conn, err := app.connectToDb()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	m, err := migrate.New(conn, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var steps []migrate.Step
+
+	switch args[0] {
+	case "up":
+		steps, err = m.Up(ctx)
+	case "down":
+		steps, err = m.Down(ctx)
+	case "to":
+		steps, err = m.To(ctx, version)
+	case "baseline":
+		steps, err = m.Baseline(ctx, version)
+	case "status":
+		return printMigrationStatus(ctx, m)
+	}
+
+	printMigrationSteps(steps)
+	return err
+}
+
+// apply pending migrations before the server starts, for -migrate
+func migrateUp(db *sql.DB) error {
+	m, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	steps, err := m.Up(context.Background())
+	printMigrationSteps(steps)
+	return err
+}
+
+func printMigrationSteps(steps []migrate.Step) {
+	for _, step := range steps {
+		switch {
+		case step.Revert:
+			fmt.Println("reverted", step.Migration)
+		case step.RecordOnly:
+			fmt.Println("recorded", step.Migration)
+		default:
+			fmt.Println("applied", step.Migration)
+		}
+	}
+}
+
+func printMigrationStatus(ctx context.Context, m *migrate.Migrator) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MIGRATION\tSTATUS\tAPPLIED AT")
+	for _, s := range statuses {
+		status, appliedAt := "pending", ""
+		if s.Applied {
+			status = "applied"
+			appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		if s.Unknown {
+			status = "applied, unknown to this build"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", s.Migration, status, appliedAt)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/toluhikay/go-react/internal/models"
+)
+
+const (
+	defaultMoviesLimit = 20
+	maxMoviesLimit     = 100
+)
+
+// what a movie listing cursor carries, the sort is included so a cursor
+// can't be used with a different ordering than the one it came from
+type movieCursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d,omitempty"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+func encodeMovieCursor(c movieCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeMovieCursor(s string) (movieCursor, error) {
+	var c movieCursor
+
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, errors.New("invalid cursor")
+	}
+
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return c, errors.New("invalid cursor")
+	}
+
+	return c, nil
+}
+
+// the value of the sort column for a movie, in the form the cursor keeps it
+func movieSortValue(movie *models.Movie, sort string) string {
+	switch sort {
+	case models.MovieSortReleaseDate:
+		return movie.ReleaseDate.Format("2006-01-02")
+	case models.MovieSortRuntime:
+		return strconv.Itoa(movie.RunTime)
+	case models.MovieSortCreatedAt:
+		return movie.CreatedAt.Format(time.RFC3339Nano)
+	default:
+		return movie.Title
+	}
+}
+
+// whether a cursor value parses as the sort column's type, so a tampered
+// cursor is refused here rather than failing the cast in the database
+func validMovieSortValue(sort, value string) bool {
+	var err error
+	switch sort {
+	case models.MovieSortReleaseDate:
+		_, err = time.Parse("2006-01-02", value)
+	case models.MovieSortRuntime:
+		_, err = strconv.ParseInt(value, 10, 32)
+	case models.MovieSortCreatedAt:
+		_, err = time.Parse(time.RFC3339Nano, value)
+	}
+	return err == nil
+}
+
+// read a list of values given either repeated or comma separated
+func queryList(values url.Values, key string) []string {
+	var list []string
+	for _, value := range values[key] {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				list = append(list, v)
+			}
+		}
+	}
+	return list
+}
+
+// build the movie query from the request's query string
+func parseMovieQuery(r *http.Request) (models.MovieQuery, error) {
+	values := r.URL.Query()
+
+	q := models.MovieQuery{
+		Sort:    models.MovieSortTitle,
+		Ratings: queryList(values, "rating"),
+	}
+
+	// a leading - sorts descending, e.g. sort=-release_date
+	if sort := values.Get("sort"); sort != "" {
+		q.Desc = strings.HasPrefix(sort, "-")
+		q.Sort = strings.TrimPrefix(sort, "-")
+
+		switch q.Sort {
+		case models.MovieSortTitle, models.MovieSortReleaseDate, models.MovieSortRuntime, models.MovieSortCreatedAt:
+		default:
+			return q, fmt.Errorf("sort must be one of title, release_date, runtime or created_at")
+		}
+	}
+
+	var err error
+	q.Limit, err = queryInt(r, "limit", defaultMoviesLimit)
+	if err != nil {
+		return q, err
+	}
+	if q.Limit > maxMoviesLimit {
+		q.Limit = maxMoviesLimit
+	}
+
+	ints := map[string]*int{
+		"year_from":   &q.YearFrom,
+		"year_to":     &q.YearTo,
+		"runtime_min": &q.RuntimeMin,
+		"runtime_max": &q.RuntimeMax,
+	}
+	for key, n := range ints {
+		*n, err = queryInt(r, key, 0)
+		if err != nil {
+			return q, err
+		}
+	}
+
+	for _, g := range queryList(values, "genre") {
+		id, err := strconv.Atoi(g)
+		if err != nil {
+			return q, errors.New("genre must be a list of genre ids")
+		}
+		q.Genres = append(q.Genres, id)
+	}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		c, err := decodeMovieCursor(cursor)
+		if err != nil {
+			return q, err
+		}
+		if c.Sort != q.Sort || c.Desc != q.Desc {
+			return q, errors.New("cursor was made for a different sort")
+		}
+		if !validMovieSortValue(c.Sort, c.Value) {
+			return q, errors.New("invalid cursor")
+		}
+		q.After = &models.MovieCursor{Value: c.Value, ID: c.ID}
+	}
+
+	return q, nil
+}
+
+// a link to the same listing with the cursor swapped out
+func movieListLink(r *http.Request, cursor string) string {
+	values := r.URL.Query()
+	values.Del("cursor")
+	if cursor != "" {
+		values.Set("cursor", cursor)
+	}
+
+	link := url.URL{Path: r.URL.Path, RawQuery: values.Encode()}
+	return link.String()
+}
+
+// list a page of the catalogue. Used by both the public and the admin listing
+func (app *application) listMovies(w http.ResponseWriter, r *http.Request) {
+	q, err := parseMovieQuery(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// ask for one extra to know whether there is another page
+	limit := q.Limit
+	q.Limit++
+
+	movies, err := app.DB.ListMovies(r.Context(), q)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	var payload = struct {
+		Movies   []*models.Movie `json:"movies"`
+		Metadata struct {
+			Limit      int    `json:"limit"`
+			Sort       string `json:"sort"`
+			HasMore    bool   `json:"has_more"`
+			NextCursor string `json:"next_cursor,omitempty"`
+		} `json:"metadata"`
+	}{
+		Movies: movies,
+	}
+	payload.Metadata.Limit = limit
+	payload.Metadata.Sort = r.URL.Query().Get("sort")
+	if payload.Metadata.Sort == "" {
+		payload.Metadata.Sort = models.MovieSortTitle
+	}
+
+	links := []string{fmt.Sprintf(`<%s>; rel="first"`, movieListLink(r, ""))}
+
+	if len(movies) > limit {
+		payload.Movies = movies[:limit]
+		last := payload.Movies[limit-1]
+
+		payload.Metadata.HasMore = true
+		payload.Metadata.NextCursor = encodeMovieCursor(movieCursor{
+			Sort:  q.Sort,
+			Desc:  q.Desc,
+			Value: movieSortValue(last, q.Sort),
+			ID:    last.ID,
+		})
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, movieListLink(r, payload.Metadata.NextCursor)))
+	}
+
+	headers := http.Header{}
+	headers.Set("Link", strings.Join(links, ", "))
+
+	_ = app.writeJSON(w, http.StatusOK, payload, headers)
+}
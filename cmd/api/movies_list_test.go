@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/toluhikay/go-react/internal/models"
+)
+
+func TestMovieCursorRoundTrip(t *testing.T) {
+	movie := &models.Movie{
+		ID:          7,
+		Title:       "Highlander",
+		ReleaseDate: time.Date(1986, time.March, 7, 0, 0, 0, 0, time.UTC),
+		RunTime:     116,
+		CreatedAt:   time.Date(2022, time.September, 23, 10, 4, 5, 123456000, time.UTC),
+	}
+
+	for _, sort := range []string{models.MovieSortTitle, models.MovieSortReleaseDate, models.MovieSortRuntime, models.MovieSortCreatedAt} {
+		t.Run(sort, func(t *testing.T) {
+			c := movieCursor{Sort: sort, Desc: true, Value: movieSortValue(movie, sort), ID: movie.ID}
+
+			decoded, err := decodeMovieCursor(encodeMovieCursor(c))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decoded != c {
+				t.Errorf("decoded %+v, want %+v", decoded, c)
+			}
+			if !validMovieSortValue(sort, decoded.Value) {
+				t.Errorf("value %q doesn't parse back for %s", decoded.Value, sort)
+			}
+		})
+	}
+}
+
+func TestDecodeMovieCursorRejects(t *testing.T) {
+	for _, s := range []string{"not base64!", base64.RawURLEncoding.EncodeToString([]byte("not json"))} {
+		if _, err := decodeMovieCursor(s); err == nil {
+			t.Errorf("decodeMovieCursor(%q) accepted it", s)
+		}
+	}
+}
+
+func TestParseMovieQuery(t *testing.T) {
+	cursor := encodeMovieCursor
+
+	tests := []struct {
+		name  string
+		query string
+		want  models.MovieQuery
+		ok    bool
+	}{
+		{"defaults", "", models.MovieQuery{Sort: models.MovieSortTitle, Limit: defaultMoviesLimit}, true},
+		{"descending sort", "sort=-release_date", models.MovieQuery{Sort: models.MovieSortReleaseDate, Desc: true, Limit: defaultMoviesLimit}, true},
+		{"unknown sort", "sort=rating", models.MovieQuery{}, false},
+		{"limit capped", "limit=500", models.MovieQuery{Sort: models.MovieSortTitle, Limit: maxMoviesLimit}, true},
+		{"zero limit", "limit=0", models.MovieQuery{}, false},
+		{
+			"filters",
+			"rating=PG,R&rating=G&genre=1,2&year_from=1980&runtime_max=120",
+			models.MovieQuery{
+				Sort:       models.MovieSortTitle,
+				Limit:      defaultMoviesLimit,
+				Ratings:    []string{"PG", "R", "G"},
+				Genres:     []int{1, 2},
+				YearFrom:   1980,
+				RuntimeMax: 120,
+			},
+			true,
+		},
+		{"genre not an id", "genre=drama", models.MovieQuery{}, false},
+		{"negative year", "year_from=-1", models.MovieQuery{}, false},
+		{
+			"cursor",
+			"sort=runtime&cursor=" + cursor(movieCursor{Sort: "runtime", Value: "116", ID: 7}),
+			models.MovieQuery{Sort: models.MovieSortRuntime, Limit: defaultMoviesLimit, After: &models.MovieCursor{Value: "116", ID: 7}},
+			true,
+		},
+		{"cursor for another sort", "sort=title&cursor=" + cursor(movieCursor{Sort: "runtime", Value: "116", ID: 7}), models.MovieQuery{}, false},
+		{"cursor for another direction", "sort=-runtime&cursor=" + cursor(movieCursor{Sort: "runtime", Value: "116", ID: 7}), models.MovieQuery{}, false},
+		{"runtime cursor not a number", "sort=runtime&cursor=" + cursor(movieCursor{Sort: "runtime", Value: "1; drop", ID: 7}), models.MovieQuery{}, false},
+		{"date cursor not a date", "sort=release_date&cursor=" + cursor(movieCursor{Sort: "release_date", Value: "yesterday", ID: 7}), models.MovieQuery{}, false},
+		{"timestamp cursor not a timestamp", "sort=created_at&cursor=" + cursor(movieCursor{Sort: "created_at", Value: "2022-09-23", ID: 7}), models.MovieQuery{}, false},
+		{"garbled cursor", "cursor=abc", models.MovieQuery{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/movies?"+tt.query, nil)
+
+			q, err := parseMovieQuery(r)
+			if !tt.ok {
+				if err == nil {
+					t.Fatalf("parseMovieQuery accepted %q", tt.query)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(q, tt.want) {
+				t.Errorf("parseMovieQuery = %+v, want %+v", q, tt.want)
+			}
+		})
+	}
+}
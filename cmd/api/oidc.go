@@ -0,0 +1,236 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/toluhikay/go-react/internal/models"
+	"github.com/toluhikay/go-react/internal/oidc"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	oidcStateExpiry = time.Minute * 10
+	amrFederated    = "fed"
+)
+
+// what we need to remember between sending the user to the provider and them coming back
+type oidcStateClaims struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	jwt.RegisteredClaims
+}
+
+// send the user to the identity provider to log in
+func (app *application) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.errorJSON(w, errors.New("single sign-on is not configured"), http.StatusNotFound)
+		return
+	}
+
+	state, err := oidc.RandomString()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	redirect, err := app.oidc.AuthCodeURL(r.Context(), state, nonce, challenge)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadGateway)
+		return
+	}
+
+	// keep state, nonce and verifier in a signed cookie so nothing is stored server side
+	signed, err := app.auth.Keys.Sign(oidcStateClaims{
+		State:    state,
+		Nonce:    nonce,
+		Verifier: verifier,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    app.auth.Issuer,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(oidcStateExpiry)),
+		},
+	})
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// lax so the cookie comes back on the provider's redirect
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/auth/oidc",
+		Value:    signed,
+		MaxAge:   int(oidcStateExpiry.Seconds()),
+		SameSite: http.SameSiteLaxMode,
+		HttpOnly: true,
+		Secure:   true,
+	})
+
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// the provider sends the user back here with an authorization code
+func (app *application) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.errorJSON(w, errors.New("single sign-on is not configured"), http.StatusNotFound)
+		return
+	}
+
+	// the state cookie is single use whatever happens next
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/auth/oidc",
+		Value:    "",
+		MaxAge:   -1,
+		SameSite: http.SameSiteLaxMode,
+		HttpOnly: true,
+		Secure:   true,
+	})
+
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		app.errorJSON(w, errors.New("single sign-on failed: "+e), http.StatusUnauthorized)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		app.errorJSON(w, errors.New("missing single sign-on state"), http.StatusUnauthorized)
+		return
+	}
+
+	saved := &oidcStateClaims{}
+	_, err = jwt.ParseWithClaims(cookie.Value, saved, app.auth.Keys.Keyfunc)
+	if err != nil || saved.Issuer != app.auth.Issuer || saved.State == "" || saved.State != query.Get("state") {
+		app.errorJSON(w, errors.New("invalid single sign-on state"), http.StatusUnauthorized)
+		return
+	}
+
+	rawIDToken, err := app.oidc.Exchange(r.Context(), query.Get("code"), saved.Verifier)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	idToken, err := app.oidc.VerifyIDToken(r.Context(), rawIDToken, saved.Nonce)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.oidcUser(idToken)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	// pass on the provider's mfa so roles that require it work with sso
+	amr := []string{amrFederated}
+	for _, m := range idToken.AMR {
+		if m == amrMFA {
+			amr = append(amr, amrMFA)
+			break
+		}
+	}
+
+	// a second factor set up here still has to be used unless the provider
+	// already asked for one, signing in through it is no way around it
+	if user.TOTPEnabled && len(amr) == 1 {
+		app.mfaChallenge(w, user, purposeSSOMFAChallenge)
+		return
+	}
+
+	app.login(w, r, user, amr)
+}
+
+// find the local user for an id token, linking by verified email or creating one
+func (app *application) oidcUser(idToken *oidc.IDTokenClaims) (*models.User, error) {
+	user, err := app.DB.GetUserByIdentity(idToken.Issuer, idToken.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	email := strings.ToLower(strings.TrimSpace(idToken.Email))
+	if email == "" {
+		return nil, errors.New("the identity provider did not share an email address")
+	}
+
+	user, err = app.DB.GetUserByEMail(email)
+	if err == nil {
+		// only link to an existing account when the provider vouches for the email,
+		// otherwise anyone could claim someone else's account
+		if !idToken.EmailVerified {
+			return nil, errors.New("an account with that email already exists, verify the email with your identity provider to link it")
+		}
+
+		err = app.DB.LinkIdentity(user.ID, idToken.Issuer, idToken.Subject)
+		if err != nil {
+			return nil, err
+		}
+
+		if !user.EmailVerified {
+			err = app.DB.VerifyUserEmail(user.ID, user.Email)
+			if err != nil {
+				return nil, err
+			}
+			user.EmailVerified = true
+		}
+
+		return user, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	// the same goes for a new account, or a provider account claiming someone
+	// else's address would keep its owner from registering it
+	if !idToken.EmailVerified {
+		return nil, errors.New("verify your email with your identity provider before signing in")
+	}
+
+	firstName, lastName := idToken.GivenName, idToken.FamilyName
+	if firstName == "" && lastName == "" {
+		firstName, lastName, _ = strings.Cut(strings.TrimSpace(idToken.Name), " ")
+	}
+
+	// no password, these users can only log in through the provider
+	newUser := models.User{
+		FirstName:     firstName,
+		LastName:      lastName,
+		Email:         email,
+		Password:      "",
+		EmailVerified: true,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+
+	newUser.ID, err = app.DB.InsertUser(newUser, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.DB.LinkIdentity(newUser.ID, idToken.Issuer, idToken.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	return &newUser, nil
+}
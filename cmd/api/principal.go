@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type contextKey string
+
+const principalContextKey = contextKey("principal")
+
+// who a request was authenticated as, put in the request context by authRequired
+type Principal struct {
+	UserID int
+	Name   string
+	Roles  []string
+	// the permissions the token carries, for an api key these are its scopes
+	Scopes    []string
+	TokenID   string
+	TokenType string
+	SessionID string
+	AMR       []string
+	// the admin really behind the request when impersonating, otherwise 0
+	ActorID int
+	// when the credentials stop being valid, zero when they don't expire
+	Expires time.Time
+}
+
+// build the principal from verified claims
+func newPrincipal(claims *Claims) (*Principal, error) {
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, errors.New("invalid token subject")
+	}
+
+	p := &Principal{
+		UserID:    userID,
+		Name:      claims.Name,
+		Roles:     claims.Roles,
+		Scopes:    claims.Permissions,
+		TokenID:   claims.ID,
+		TokenType: claims.Type,
+		SessionID: claims.SessionID,
+		AMR:       claims.AMR,
+	}
+	if claims.ExpiresAt != nil {
+		p.Expires = claims.ExpiresAt.Time
+	}
+
+	if claims.Actor != nil {
+		p.ActorID, err = strconv.Atoi(claims.Actor.Subject)
+		if err != nil {
+			return nil, errors.New("invalid token actor")
+		}
+	}
+
+	return p, nil
+}
+
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Principal) HasPermission(permission string) bool {
+	for _, s := range p.Scopes {
+		if s == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Principal) Impersonating() bool {
+	return p.ActorID != 0
+}
+
+// check if the request came from an api key rather than a logged in session
+func (p *Principal) APIKey() bool {
+	return p.TokenType == tokenTypeAPIKey
+}
+
+func contextWithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey, p)
+}
+
+// get the principal authRequired stored in the context
+func principalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalContextKey).(*Principal)
+	return p, ok && p != nil
+}
+
+// get the principal for a request, writing a 401 if there isn't one, which
+// only happens when a route is missing authRequired
+func (app *application) principal(w http.ResponseWriter, r *http.Request) (*Principal, bool) {
+	p, ok := principalFromContext(r.Context())
+	if !ok {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return nil, false
+	}
+	return p, true
+}
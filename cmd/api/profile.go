@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+func (app *application) getMe(w http.ResponseWriter, r *http.Request) {
+	principal, ok := app.principal(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := app.userWithRoles(principal.UserID)
+	if err != nil {
+		app.errorJSON(w, errors.New("unknown user"), http.StatusUnauthorized)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, user)
+}
+
+// change the user's own name or email. Changing the email needs the current
+// password, since the email is where password resets go. A new email is
+// unverified until the link sent to it is followed, and the old one is told
+// about the change
+func (app *application) updateMe(w http.ResponseWriter, r *http.Request) {
+	principal, ok := app.principal(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := app.userWithRoles(principal.UserID)
+	if err != nil {
+		app.errorJSON(w, errors.New("unknown user"), http.StatusUnauthorized)
+		return
+	}
+
+	// only the fields that were sent are changed
+	var payload struct {
+		FirstName *string `json:"first_name"`
+		LastName  *string `json:"last_name"`
+		Email     *string `json:"email"`
+		// needed to change the email
+		CurrentPassword string `json:"current_password"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if payload.FirstName != nil {
+		user.FirstName = strings.TrimSpace(*payload.FirstName)
+	}
+	if payload.LastName != nil {
+		user.LastName = strings.TrimSpace(*payload.LastName)
+	}
+	if user.FirstName == "" || user.LastName == "" {
+		app.errorJSON(w, errors.New("first and last name are required"))
+		return
+	}
+
+	oldEmail := user.Email
+	emailChanged := false
+	if payload.Email != nil {
+		// a stolen access token shouldn't be enough to point the account, and
+		// its password resets, somewhere else
+		if app.loginLocked(w, r, user.Email) {
+			return
+		}
+		valid, err := user.PasswordMatch(payload.CurrentPassword)
+		if err != nil || !valid {
+			app.loginFailed(r, user.Email)
+			app.errorJSON(w, errors.New("current password is incorrect"), http.StatusForbidden)
+			return
+		}
+
+		email := strings.ToLower(strings.TrimSpace(*payload.Email))
+		if err := validateEmail(email); err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+		if !app.emailAvailable(w, email, user.ID) {
+			return
+		}
+		if email != user.Email {
+			user.Email = email
+			user.EmailVerified = false
+			emailChanged = true
+		}
+	}
+
+	err = app.DB.UpdateUser(*user, nil)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if emailChanged {
+		// the email has changed either way, so a notice that doesn't go out
+		// shouldn't fail the request
+		err = app.sendEmailChangedNotice(user, oldEmail)
+		if err != nil {
+			log.Println(err)
+		}
+
+		err = app.sendVerificationEmail(user)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, user)
+}
+
+// change the password after checking the current one. Every session is
+// revoked and this device gets a fresh one
+func (app *application) changeMyPassword(w http.ResponseWriter, r *http.Request) {
+	principal, ok := app.principal(w, r)
+	if !ok {
+		return
+	}
+
+	if principal.APIKey() {
+		app.errorJSON(w, errors.New("passwords can only be changed from a logged in session"), http.StatusForbidden)
+		return
+	}
+
+	var payload struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	user, err := app.DB.GetUSerById(principal.UserID)
+	if err != nil {
+		app.errorJSON(w, errors.New("unknown user"), http.StatusUnauthorized)
+		return
+	}
+
+	// a stolen access token shouldn't be a way around the login lockout
+	if app.loginLocked(w, r, user.Email) {
+		return
+	}
+
+	valid, err := user.PasswordMatch(payload.CurrentPassword)
+	if err != nil || !valid {
+		app.loginFailed(r, user.Email)
+		app.errorJSON(w, errors.New("current password is incorrect"), http.StatusForbidden)
+		return
+	}
+
+	if err := validatePassword(payload.NewPassword); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	hash, err := hashPassword(payload.NewPassword)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.DB.ChangePassword(user.ID, hash)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.login(w, r, user, principal.AMR)
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/toluhikay/go-react/internal/models"
 )
 
 func (app *application) routes() http.Handler {
@@ -12,26 +13,70 @@ func (app *application) routes() http.Handler {
 	mux := chi.NewRouter()
 
 	// addigng middlewares
+	mux.Use(middleware.RequestID)
 	mux.Use(middleware.Recoverer)
 	mux.Use(app.enableCORS)
 
 	// adding routes
 	mux.Get("/", app.Home)
+	mux.Get("/.well-known/jwks.json", app.jwks)
 	mux.Post("/authenticate", app.authenticate)
+	mux.Post("/authenticate/mfa", app.authenticateMFA)
+	mux.Get("/auth/oidc/login", app.oidcLogin)
+	mux.Get("/auth/oidc/callback", app.oidcCallback)
+	mux.Post("/register", app.register)
+	mux.Get("/verify-email", app.verifyEmail)
+	mux.Post("/password/forgot", app.forgotPassword)
+	mux.Post("/password/reset", app.resetPassword)
 	mux.Get("/allmovies", app.AllMovies)
+	mux.Get("/search", app.searchMovies)
+	mux.Get("/suggest", app.suggestions)
+	mux.With(app.authOptional).Get("/graph", app.graphQL)
+	mux.With(app.authOptional).Post("/graph", app.graphQL)
 	mux.Get("/refresh", app.refreshToken)
 	mux.Get("/logout", app.logOut)
 	mux.Get("/movies/{id}", app.GetOneMovie)
 	mux.Get("/allgenres", app.AllGenres)
 	mux.Get("/movies/genres/{id}", app.AllMoviesByGenre)
 
+	mux.Route("/me", func(mux chi.Router) {
+		mux.Use(app.authRequired)
+		mux.Use(app.sessionRequired)
+		mux.Get("/", app.getMe)
+		mux.Patch("/", app.updateMe)
+		mux.Post("/password", app.changeMyPassword)
+		mux.Get("/sessions", app.mySessions)
+		mux.Delete("/sessions/{id}", app.revokeMySession)
+		mux.Post("/sessions/revoke-all", app.revokeAllMySessions)
+		mux.Post("/mfa/totp", app.enrollTOTP)
+		mux.Post("/mfa/totp/confirm", app.confirmTOTP)
+		mux.Get("/api-keys", app.myAPIKeys)
+		mux.Post("/api-keys", app.createAPIKey)
+		mux.Delete("/api-keys/{id}", app.revokeAPIKey)
+	})
+
 	mux.Route("/admin", func(mux chi.Router) {
 		mux.Use(app.authRequired)
-		mux.Get("/movies", app.MovieCatalogue)
-		mux.Get("/movie/{id}", app.GetOneMovieForEdit)
-		mux.Put("/movies/0", app.InsertMovie)
-		mux.Patch("/movies/{id}", app.UpdateMovie)
-		mux.Delete("/movies/{id}", app.DeleteMovie)
+		mux.With(app.requirePermission(models.PermMoviesRead)).Get("/movies", app.MovieCatalogue)
+		mux.With(app.requirePermission(models.PermMoviesRead)).Get("/movie/{id}", app.GetOneMovieForEdit)
+		mux.With(app.requirePermission(models.PermMoviesWrite)).Put("/movies/0", app.InsertMovie)
+		mux.With(app.requirePermission(models.PermMoviesWrite)).Patch("/movies/{id}", app.UpdateMovie)
+		mux.With(app.requirePermission(models.PermMoviesDelete)).Delete("/movies/{id}", app.DeleteMovie)
+
+		mux.Route("/users", func(mux chi.Router) {
+			mux.Use(app.requirePermission(models.PermUsersManage))
+			mux.Get("/", app.allUsers)
+			mux.Post("/", app.createUser)
+			mux.Get("/{id}", app.getUser)
+			mux.Patch("/{id}", app.updateUser)
+			mux.Delete("/{id}", app.deleteUser)
+			mux.Post("/{id}/disable", app.disableUser)
+			mux.Post("/{id}/enable", app.enableUser)
+			mux.Post("/{id}/password-reset", app.forcePasswordReset)
+			mux.Post("/{id}/unlock", app.unlockUser)
+		})
+		mux.With(app.requirePermission(models.PermUsersImpersonate)).Post("/users/{id}/impersonate", app.impersonateUser)
+		mux.With(app.requirePermission(models.PermAuditRead)).Get("/audit", app.auditLog)
 	})
 
 	return mux
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/toluhikay/go-react/internal/models"
+)
+
+const (
+	defaultSearchPageSize = 20
+	maxSearchPageSize     = 100
+	maxSearchQueryLength  = 200
+)
+
+func (app *application) searchMovies(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+
+	search := models.MovieSearch{
+		Query:   strings.TrimSpace(values.Get("q")),
+		Ratings: queryList(values, "rating"),
+	}
+
+	if search.Query == "" {
+		app.errorJSON(w, errors.New("q is required"))
+		return
+	}
+	if len(search.Query) > maxSearchQueryLength {
+		app.errorJSON(w, errors.New("q is too long"))
+		return
+	}
+
+	for _, g := range queryList(values, "genre") {
+		id, err := strconv.Atoi(g)
+		if err != nil {
+			app.errorJSON(w, errors.New("genre must be a list of genre ids"))
+			return
+		}
+		search.Genres = append(search.Genres, id)
+	}
+
+	page, err := queryInt(r, "page", 1)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	pageSize, err := queryInt(r, "page_size", defaultSearchPageSize)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	if pageSize > maxSearchPageSize {
+		pageSize = maxSearchPageSize
+	}
+
+	search.Limit = pageSize
+	search.Offset = (page - 1) * pageSize
+
+	results, total, err := app.DB.SearchMovies(r.Context(), search)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	var payload = struct {
+		Results  []*models.MovieSearchResult `json:"results"`
+		Metadata struct {
+			Query      string `json:"query"`
+			Page       int    `json:"page"`
+			PageSize   int    `json:"page_size"`
+			TotalPages int    `json:"total_pages"`
+			Total      int    `json:"total"`
+		} `json:"metadata"`
+	}{
+		Results: results,
+	}
+	payload.Metadata.Query = search.Query
+	payload.Metadata.Page = page
+	payload.Metadata.PageSize = pageSize
+	payload.Metadata.Total = total
+	payload.Metadata.TotalPages = (total + pageSize - 1) / pageSize
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (app *application) mySessions(w http.ResponseWriter, r *http.Request) {
+	principal, ok := app.principal(w, r)
+	if !ok {
+		return
+	}
+
+	sessions, err := app.DB.GetUserSessions(principal.UserID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// flag the session this request is coming from
+	for _, session := range sessions {
+		session.Current = session.ID == principal.SessionID
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, sessions)
+}
+
+func (app *application) revokeMySession(w http.ResponseWriter, r *http.Request) {
+	principal, ok := app.principal(w, r)
+	if !ok {
+		return
+	}
+
+	sessionID := chi.URLParam(r, "id")
+
+	err := app.DB.RevokeUserSession(principal.UserID, sessionID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("session not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// revoking the session we are using is the same as logging out
+	if sessionID == principal.SessionID {
+		http.SetCookie(w, app.auth.GetExpiredRefreshCookie())
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "session revoked",
+	}
+
+	_ = app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// sign out everywhere, including this device
+func (app *application) revokeAllMySessions(w http.ResponseWriter, r *http.Request) {
+	principal, ok := app.principal(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.DB.RevokeUserSessions(principal.UserID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, app.auth.GetExpiredRefreshCookie())
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "signed out of every session",
+	}
+
+	_ = app.writeJSON(w, http.StatusAccepted, resp)
+}
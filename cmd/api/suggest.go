@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/toluhikay/go-react/internal/models"
+	"github.com/toluhikay/go-react/internal/suggest"
+)
+
+// kinds of suggestion
+const (
+	suggestMovie = "movie"
+)
+
+const (
+	defaultSuggestLimit = 10
+	maxSuggestLimit     = 20
+	maxSuggestPrefix    = 100
+)
+
+// fill the suggestion index with every movie in the catalogue
+func (app *application) loadSuggestions() error {
+	movies, err := app.DB.AllMovies()
+	if err != nil {
+		return err
+	}
+
+	for _, movie := range movies {
+		app.suggestMovie(movie)
+	}
+
+	return nil
+}
+
+// add a movie to the suggestion index, or update its title
+func (app *application) suggestMovie(movie *models.Movie) {
+	app.suggest.Put(suggest.Entry{
+		Kind: suggestMovie,
+		ID:   movie.ID,
+		Text: movie.Title,
+	})
+}
+
+func (app *application) suggestions(w http.ResponseWriter, r *http.Request) {
+	// a trailing space is kept, "the " only matches the whole word
+	prefix := r.URL.Query().Get("prefix")
+	if strings.TrimSpace(prefix) == "" {
+		app.errorJSON(w, errors.New("prefix is required"))
+		return
+	}
+	if len(prefix) > maxSuggestPrefix {
+		app.errorJSON(w, errors.New("prefix is too long"))
+		return
+	}
+
+	limit, err := queryInt(r, "limit", defaultSuggestLimit)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	if limit > app.suggest.Max() {
+		limit = app.suggest.Max()
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, app.suggest.Suggest(prefix, limit))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
+
+// refuse the login when the client ip or the account is locked out, writing the response when it is
+func (app *application) loginLocked(w http.ResponseWriter, r *http.Request, email string) bool {
+	wait, err := app.ipLimiter.Locked(ipKey(clientIP(r)))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return true
+	}
+	if wait > 0 {
+		setRetryAfter(w, wait)
+		app.errorJSON(w, errors.New("too many failed login attempts, try again later"), http.StatusTooManyRequests)
+		return true
+	}
+
+	wait, err = app.accountLimiter.Locked(accountKey(email))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return true
+	}
+	if wait > 0 {
+		setRetryAfter(w, wait)
+		app.errorJSON(w, errors.New("account is temporarily locked, try again later"), http.StatusLocked)
+		return true
+	}
+
+	return false
+}
+
+// count a failed login against both the client ip and the account
+func (app *application) loginFailed(r *http.Request, email string) {
+	_, err := app.ipLimiter.Fail(ipKey(clientIP(r)))
+	if err != nil {
+		log.Println(err)
+	}
+
+	lockout, err := app.accountLimiter.Fail(accountKey(email))
+	if err != nil {
+		log.Println(err)
+	}
+	if lockout > 0 {
+		log.Printf("locking logins for %s for %s after repeated failures", email, lockout)
+	}
+
+	app.audit(r, auditEvent{
+		Action:   auditLoginFailed,
+		Entity:   "account",
+		EntityID: strings.ToLower(strings.TrimSpace(email)),
+	})
+}
+
+// a full login clears the account's failures, the ip keeps its count
+func (app *application) loginSucceeded(email string) {
+	err := app.accountLimiter.Reset(accountKey(email))
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (app *application) unlockUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	user, err := app.DB.GetUSerById(id)
+	if err != nil {
+		app.errorJSON(w, errors.New("user not found"), http.StatusNotFound)
+		return
+	}
+
+	err = app.accountLimiter.Reset(accountKey(user.Email))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, auditEvent{Action: auditUserUnlock, Entity: "user", EntityID: user.ID})
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "account unlocked",
+	}
+
+	_ = app.writeJSON(w, http.StatusAccepted, resp)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// create a random, url safe token to hand out once, e.g. in a reset link
+func generateRandomToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hash a token before it is stored, so a database leak doesn't leak usable tokens
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// create a random id for tokens and sessions, e.g. the jti claim
+func newTokenID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		// the system's random source is broken, nothing sensible can carry on
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,106 @@
+// Package events is an in-process publish/subscribe bus for things that
+// happened in the app, like a movie being added.
+package events
+
+import (
+	"log"
+	"sync"
+)
+
+// event types
+const (
+	MovieCreated = "movie.created"
+	MovieUpdated = "movie.updated"
+	MovieDeleted = "movie.deleted"
+)
+
+type Event struct {
+	Type string
+	// what the event is about, a *models.Movie for the movie events. For a
+	// deleted movie it is the movie as it was before it was deleted
+	Payload interface{}
+}
+
+type subscriber struct {
+	ch    chan Event
+	types map[string]bool
+	// events dropped since the subscriber last kept up
+	dropped int
+}
+
+// delivers published events to every subscriber interested in them. Publishing
+// never blocks, a subscriber that falls more than its buffer behind misses
+// events rather than holding up the publisher
+type Bus struct {
+	mu     sync.Mutex
+	buffer int
+	next   int
+	subs   map[int]*subscriber
+}
+
+// create a bus whose subscribers can fall buffer events behind
+func New(buffer int) *Bus {
+	return &Bus{
+		buffer: buffer,
+		subs:   make(map[int]*subscriber),
+	}
+}
+
+// receive events of the given types, or every event when no types are given.
+// Call cancel to stop, which closes the channel
+func (b *Bus) Subscribe(types ...string) (<-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &subscriber{ch: make(chan Event, b.buffer)}
+	if len(types) > 0 {
+		sub.types = make(map[string]bool, len(types))
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+
+	id := b.next
+	b.next++
+	b.subs[id] = sub
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.subs, id)
+			close(sub.ch)
+		})
+	}
+
+	return sub.ch, cancel
+}
+
+func (b *Bus) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, sub := range b.subs {
+		if sub.types != nil && !sub.types[e.Type] {
+			continue
+		}
+
+		select {
+		case sub.ch <- e:
+			if sub.dropped > 0 {
+				log.Printf("events: subscriber caught up after missing %d events", sub.dropped)
+				sub.dropped = 0
+			}
+		default:
+			sub.dropped++
+		}
+	}
+}
+
+// how many subscribers there are
+func (b *Bus) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
@@ -0,0 +1,64 @@
+package graph
+
+import (
+	"context"
+)
+
+type contextKey string
+
+const (
+	viewerContextKey = contextKey("viewer")
+	hooksContextKey  = contextKey("hooks")
+)
+
+// who a request is made by, put in the context by whoever serves the graph
+type Viewer struct {
+	UserID      int
+	Permissions []string
+	// impersonation sessions can look but not change anything
+	ReadOnly bool
+}
+
+func (v *Viewer) HasPermission(permission string) bool {
+	for _, p := range v.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func WithViewer(ctx context.Context, v *Viewer) context.Context {
+	return context.WithValue(ctx, viewerContextKey, v)
+}
+
+func viewerFromContext(ctx context.Context) (*Viewer, bool) {
+	v, ok := ctx.Value(viewerContextKey).(*Viewer)
+	return v, ok && v != nil
+}
+
+// check the request is allowed to make a change that needs permission, the
+// same rules as the admin routes
+func requirePermission(ctx context.Context, permission string) (*Viewer, error) {
+	v, err := requireReadPermission(ctx, permission)
+	if err != nil {
+		return nil, err
+	}
+	if v.ReadOnly {
+		return nil, newError(CodeForbidden, "changes can't be made while impersonating a user")
+	}
+	return v, nil
+}
+
+// like requirePermission for something that only looks, which impersonation
+// sessions can do
+func requireReadPermission(ctx context.Context, permission string) (*Viewer, error) {
+	v, ok := viewerFromContext(ctx)
+	if !ok {
+		return nil, newError(CodeUnauthenticated, "you must be logged in to perform this action")
+	}
+	if !v.HasPermission(permission) {
+		return nil, newError(CodeForbidden, "you do not have permission to perform this action")
+	}
+	return v, nil
+}
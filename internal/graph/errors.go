@@ -0,0 +1,61 @@
+package graph
+
+import "github.com/graphql-go/graphql/gqlerrors"
+
+// error codes, sent in the extensions of an error so clients can tell them apart
+const (
+	CodeUnauthenticated = "UNAUTHENTICATED"
+	CodeForbidden       = "FORBIDDEN"
+	CodeNotFound        = "NOT_FOUND"
+	CodeBadUserInput    = "BAD_USER_INPUT"
+)
+
+// an error returned from a resolver, graphql-go puts its extensions next to
+// the message and path of the field that failed
+type Error struct {
+	Message string
+	Code    string
+	// problems with individual input fields, keyed by field name
+	Fields map[string]string
+}
+
+// an error for the whole request rather than one field, for a request that is
+// refused before it runs
+func requestError(code, message string) gqlerrors.FormattedError {
+	err := gqlerrors.NewFormattedError(message)
+	err.Extensions = map[string]interface{}{"code": code}
+	return err
+}
+
+func newError(code, message string) *Error {
+	return &Error{Message: message, Code: code}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{"code": e.Code}
+	if len(e.Fields) > 0 {
+		ext["fields"] = e.Fields
+	}
+	return ext
+}
+
+// collects problems with the input of a mutation
+type inputErrors map[string]string
+
+func (ie inputErrors) add(field, message string) {
+	if _, ok := ie[field]; !ok {
+		ie[field] = message
+	}
+}
+
+// the errors as a single error, or nil when there weren't any
+func (ie inputErrors) err() error {
+	if len(ie) == 0 {
+		return nil
+	}
+	return &Error{Message: "invalid input", Code: CodeBadUserInput, Fields: ie}
+}
@@ -1,18 +1,192 @@
 package graph
 
 import (
+	"context"
+	"errors"
+
 	"github.com/graphql-go/graphql"
-	"github.com/toluhikay/go-react/internal/models"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/toluhikay/go-react/internal/events"
+	"github.com/toluhikay/go-react/internal/repository"
+)
+
+// returned for a mutation when the graph is only allowed to run queries
+var ErrQueryOnly = errors.New("mutations must be sent with POST")
+
+const (
+	defaultLimit = 20
+	maxLimit     = 100
 )
 
 type Graph struct {
-	Movies      []*models.Movie
+	DB          repository.DatabaseRepo
 	QueryString string
-	Config      graphql.SchemaConfig
-	// fields      graphql.Fields
-	// movieType   *graphql.Object
+	// the sha256 hash of the query, sent instead of or along with it
+	QueryHash     string
+	OperationName string
+	Variables     map[string]interface{}
+	// refuse mutations, for requests that shouldn't change anything like GET
+	QueryOnly bool
+	Limits    Limits
+	// queries clients can send by hash, nil turns persisted queries off
+	Persisted *PersistedQueries
+	Hooks     Hooks
+	Config    graphql.SchemaConfig
+	schema    graphql.Schema
+	movieType *graphql.Object
+	genreType *graphql.Object
+	bus       *events.Bus
+}
+
+// create a new graph, the schema is built once and shared by every request.
+// Subscriptions are fed from the bus, a nil bus leaves them out
+func New(db repository.DatabaseRepo, bus *events.Bus) (*Graph, error) {
+	g := &Graph{DB: db, Limits: DefaultLimits, bus: bus}
+	g.movieType, g.genreType = g.types()
+
+	g.Config = graphql.SchemaConfig{
+		Query:    g.queryType(),
+		Mutation: g.mutationType(),
+	}
+	if bus != nil {
+		g.Config.Subscription = g.subscriptionType()
+	}
+
+	schema, err := graphql.NewSchema(g.Config)
+	if err != nil {
+		return nil, err
+	}
+	g.schema = schema
+
+	return g, nil
+}
+
+// a copy of the graph set up to run one request
+func (g *Graph) Request(query, operationName string, variables map[string]interface{}) *Graph {
+	req := *g
+	req.QueryString = query
+	req.OperationName = operationName
+	req.Variables = variables
+	return &req
+}
+
+// parse, validate and run the query string against the schema. Syntax,
+// validation and resolver errors are part of the result, the error is only
+// for a request that can't be run at all
+func (g *Graph) Query(ctx context.Context) (*graphql.Result, error) {
+	doc, op, errs, err := g.prepare()
+	if err != nil {
+		return nil, err
+	}
+	if len(errs) > 0 {
+		return &graphql.Result{Errors: errs}, nil
+	}
+
+	if op != nil && op.Operation == ast.OperationTypeSubscription {
+		return &graphql.Result{Errors: []gqlerrors.FormattedError{
+			requestError(CodeBadUserInput, "subscriptions are only available over a websocket"),
+		}}, nil
+	}
+
+	return g.execute(ctx, doc, op, nil), nil
 }
 
-// create a new constructor to return the Graph
+// work out the query to run, then parse and check it. The errors are what to
+// send back instead of running it
+func (g *Graph) prepare() (*ast.Document, *ast.OperationDefinition, []gqlerrors.FormattedError, error) {
+	query, register, perr := g.Persisted.resolve(g.QueryString, g.QueryHash)
+	if perr != nil {
+		return nil, nil, []gqlerrors.FormattedError{*perr}, nil
+	}
+	if query == "" {
+		return nil, nil, nil, errors.New("query is required")
+	}
+	g.QueryString = query
 
-// s
+	doc, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{
+			Body: []byte(g.QueryString),
+			Name: "GraphQL request",
+		}),
+	})
+	if err != nil {
+		return nil, nil, gqlerrors.FormatErrors(err), nil
+	}
+
+	validation := graphql.ValidateDocument(&g.schema, doc, nil)
+	if !validation.IsValid {
+		return nil, nil, validation.Errors, nil
+	}
+
+	// only queries that could run are worth keeping
+	if register {
+		g.Persisted.register(query)
+	}
+
+	// without a single operation to run there is nothing to check, execution
+	// reports the problem
+	op := operation(doc, g.OperationName)
+	if op != nil {
+		if g.QueryOnly && op.Operation == ast.OperationTypeMutation {
+			return nil, nil, nil, ErrQueryOnly
+		}
+
+		if errs := g.Limits.check(&g.schema, doc, op, g.Variables); len(errs) > 0 {
+			return nil, nil, errs, nil
+		}
+	}
+
+	return doc, op, nil, nil
+}
+
+// run a query or mutation, or a subscription for one event with the event as
+// root. Each run gets its own loaders so nothing cached outlives it
+func (g *Graph) execute(ctx context.Context, doc *ast.Document, op *ast.OperationDefinition, root interface{}) *graphql.Result {
+	if op != nil && op.Operation == ast.OperationTypeQuery && g.Limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.Limits.Timeout)
+		defer cancel()
+	}
+
+	ctx = context.WithValue(ctx, hooksContextKey, g.Hooks)
+	ctx = context.WithValue(ctx, loadersContextKey, newLoaders(ctx, g.DB))
+
+	result := graphql.Execute(graphql.ExecuteParams{
+		Schema:        g.schema,
+		Root:          root,
+		AST:           doc,
+		OperationName: g.OperationName,
+		Args:          g.Variables,
+		Context:       ctx,
+	})
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		result = &graphql.Result{Errors: []gqlerrors.FormattedError{timeoutError(g.Limits.Timeout)}}
+	}
+
+	return result
+}
+
+// the operation a request runs, the named one or the only one in the document.
+// nil when there isn't a single match, which execution reports as an error
+func operation(doc *ast.Document, name string) *ast.OperationDefinition {
+	var found *ast.OperationDefinition
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if name == "" {
+			if found != nil {
+				return nil
+			}
+			found = op
+		} else if op.Name != nil && op.Name.Value == name {
+			return op
+		}
+	}
+	return found
+}
@@ -0,0 +1,274 @@
+package graph
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// error codes for requests refused by a limit
+const (
+	CodeQueryTooDeep    = "QUERY_TOO_DEEP"
+	CodeQueryTooComplex = "QUERY_TOO_COMPLEX"
+	CodeTimeout         = "TIMEOUT"
+)
+
+// how much a single request is allowed to ask for. Depth and complexity are
+// worked out from the query before anything runs, a zero value turns a limit off
+type Limits struct {
+	// how many fields can be nested inside each other
+	MaxDepth int
+	// the most a request can cost, see Costs
+	MaxComplexity int
+	// what a field costs, keyed by "Type.field" like "Genre.movies". Fields
+	// returning objects cost 1 and scalars nothing unless listed here. What a
+	// list field selects is counted once for every item it may return
+	Costs map[string]int
+	// how many items a list without a limit argument is expected to return
+	ListSize int
+	// how long a query can run, mutations aren't cut off part way through
+	Timeout time.Duration
+}
+
+// limits that fit the catalogue as it is, a page of movies with their genres
+// and each genre's movies is well within them
+var DefaultLimits = Limits{
+	MaxDepth:      8,
+	MaxComplexity: 5000,
+	Costs: map[string]int{
+		// a full text search is the most expensive query there is
+		"Query.search": 10,
+	},
+	ListSize: 20,
+	Timeout:  time.Second * 10,
+}
+
+// error for a query that ran out of time, whatever it had resolved is dropped
+func timeoutError(timeout time.Duration) gqlerrors.FormattedError {
+	err := requestError(CodeTimeout, fmt.Sprintf("query took longer than %s", timeout))
+	err.Extensions["limit"] = timeout.String()
+	return err
+}
+
+// error for a request refused by a limit, saying which and by how much
+func limitError(code, message string, limit, actual int) gqlerrors.FormattedError {
+	err := requestError(code, message)
+	err.Extensions["limit"] = limit
+	err.Extensions["actual"] = actual
+	return err
+}
+
+// check an operation against the limits, returning the errors to send back
+// instead of running it
+func (l Limits) check(schema *graphql.Schema, doc *ast.Document, op *ast.OperationDefinition, variables map[string]interface{}) []gqlerrors.FormattedError {
+	if l.MaxDepth <= 0 && l.MaxComplexity <= 0 {
+		return nil
+	}
+
+	root := schema.QueryType()
+	switch op.Operation {
+	case ast.OperationTypeMutation:
+		root = schema.MutationType()
+	case ast.OperationTypeSubscription:
+		root = schema.SubscriptionType()
+	}
+
+	a := &analysis{
+		limits:    l,
+		schema:    schema,
+		fragments: make(map[string]*ast.FragmentDefinition),
+		variables: make(map[string]interface{}),
+	}
+	for _, def := range op.VariableDefinitions {
+		if value, ok := def.DefaultValue.(*ast.IntValue); ok {
+			a.variables[def.Variable.Name.Value], _ = strconv.Atoi(value.Value)
+		}
+	}
+	for name, value := range variables {
+		a.variables[name] = value
+	}
+	for _, def := range doc.Definitions {
+		if fragment, ok := def.(*ast.FragmentDefinition); ok {
+			a.fragments[fragment.Name.Value] = fragment
+		}
+	}
+
+	depth, cost := a.selectionSet(op.SelectionSet, root, 1, map[string]bool{})
+
+	var errs []gqlerrors.FormattedError
+	if l.MaxDepth > 0 && depth > l.MaxDepth {
+		errs = append(errs, limitError(CodeQueryTooDeep,
+			fmt.Sprintf("query is %d levels deep, the maximum depth is %d", depth, l.MaxDepth), l.MaxDepth, depth))
+	}
+	if l.MaxComplexity > 0 && cost > l.MaxComplexity {
+		errs = append(errs, limitError(CodeQueryTooComplex,
+			fmt.Sprintf("query has a complexity of %d, the maximum is %d", cost, l.MaxComplexity), l.MaxComplexity, cost))
+	}
+	return errs
+}
+
+// walks an operation adding up its depth and cost. The document has already
+// been validated so unknown fields and fragment cycles can't happen, they are
+// only guarded against
+type analysis struct {
+	limits    Limits
+	schema    *graphql.Schema
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+}
+
+// the depth and cost of a selection set whose fields are at the given depth
+func (a *analysis) selectionSet(set *ast.SelectionSet, parent *graphql.Object, depth int, spread map[string]bool) (int, int) {
+	if set == nil || parent == nil {
+		return 0, 0
+	}
+
+	maxDepth, cost := 0, 0
+	for _, selection := range set.Selections {
+		var d, c int
+
+		switch s := selection.(type) {
+		case *ast.Field:
+			d, c = a.field(s, parent, depth, spread)
+		case *ast.InlineFragment:
+			on := parent
+			if s.TypeCondition != nil {
+				on = a.object(s.TypeCondition.Name.Value, parent)
+			}
+			d, c = a.selectionSet(s.SelectionSet, on, depth, spread)
+		case *ast.FragmentSpread:
+			name := s.Name.Value
+			fragment, ok := a.fragments[name]
+			if !ok || spread[name] {
+				continue
+			}
+			spread[name] = true
+			d, c = a.selectionSet(fragment.SelectionSet, a.object(fragment.TypeCondition.Name.Value, parent), depth, spread)
+			delete(spread, name)
+		}
+
+		if d > maxDepth {
+			maxDepth = d
+		}
+		cost = capCost(cost + c)
+	}
+
+	return maxDepth, cost
+}
+
+func (a *analysis) field(field *ast.Field, parent *graphql.Object, depth int, spread map[string]bool) (int, int) {
+	name := field.Name.Value
+
+	// introspection is left alone, its types nest deeply but cost nothing to resolve
+	if strings.HasPrefix(name, "__") {
+		return 0, 0
+	}
+
+	def, ok := parent.Fields()[name]
+	if !ok {
+		return depth, 0
+	}
+
+	list := false
+	fieldType := def.Type
+	for {
+		if nonNull, ok := fieldType.(*graphql.NonNull); ok {
+			fieldType = nonNull.OfType
+			continue
+		}
+		if l, ok := fieldType.(*graphql.List); ok {
+			list = true
+			fieldType = l.OfType
+			continue
+		}
+		break
+	}
+
+	object, ok := fieldType.(*graphql.Object)
+	if !ok {
+		return depth, a.limits.Costs[parent.Name()+"."+name]
+	}
+
+	cost, ok := a.limits.Costs[parent.Name()+"."+name]
+	if !ok {
+		cost = 1
+	}
+
+	childDepth, childCost := a.selectionSet(field.SelectionSet, object, depth+1, spread)
+	if list {
+		size := a.listSize(field, def)
+		if childCost > maxCost/size {
+			childCost = maxCost
+		} else {
+			childCost *= size
+		}
+	}
+
+	if childDepth < depth {
+		childDepth = depth
+	}
+	return childDepth, capCost(cost + childCost)
+}
+
+// costs grow quickly with nested lists, they stop at maxCost so they can't overflow
+const maxCost = 1 << 30
+
+func capCost(cost int) int {
+	if cost > maxCost {
+		return maxCost
+	}
+	return cost
+}
+
+// how many items a list field can return, its limit argument when it has one
+func (a *analysis) listSize(field *ast.Field, def *graphql.FieldDefinition) int {
+	size := a.limits.ListSize
+
+	for _, arg := range def.Args {
+		if arg.Name() == "limit" {
+			if n, ok := arg.DefaultValue.(int); ok {
+				size = n
+			}
+		}
+	}
+
+	for _, arg := range field.Arguments {
+		if arg.Name.Value != "limit" {
+			continue
+		}
+		switch value := arg.Value.(type) {
+		case *ast.IntValue:
+			if n, err := strconv.Atoi(value.Value); err == nil {
+				size = n
+			}
+		case *ast.Variable:
+			if n, ok := a.variables[value.Name.Value].(float64); ok {
+				size = int(n)
+			} else if n, ok := a.variables[value.Name.Value].(int); ok {
+				size = n
+			}
+		}
+	}
+
+	// the resolvers never return more than maxLimit
+	if size > maxLimit {
+		size = maxLimit
+	}
+	if size < 1 {
+		size = 1
+	}
+	return size
+}
+
+// the object type a fragment applies to, falling back to the enclosing type
+func (a *analysis) object(name string, fallback *graphql.Object) *graphql.Object {
+	if object, ok := a.schema.Type(name).(*graphql.Object); ok {
+		return object
+	}
+	return fallback
+}
@@ -0,0 +1,130 @@
+package graph
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits Limits
+		query  string
+		// the error code the request is refused with, empty when it runs
+		code string
+	}{
+		{
+			name:   "within the depth",
+			limits: Limits{MaxDepth: 3},
+			query:  `{ movies { genres { id } } }`,
+		},
+		{
+			name:   "too deep",
+			limits: Limits{MaxDepth: 3},
+			query:  `{ movies { genres { movies { id } } } }`,
+			code:   CodeQueryTooDeep,
+		},
+		{
+			name:   "too deep through a fragment",
+			limits: Limits{MaxDepth: 3},
+			query:  `{ movies { ...g } } fragment g on Movie { genres { movies { id } } }`,
+			code:   CodeQueryTooDeep,
+		},
+		{
+			// 1 for movies and 10 for each of its 10 genres
+			name:   "within the complexity",
+			limits: Limits{MaxComplexity: 11, ListSize: 20},
+			query:  `{ movies(limit: 10) { genres { id } } }`,
+		},
+		{
+			name:   "over the complexity",
+			limits: Limits{MaxComplexity: 11, ListSize: 20},
+			query:  `{ movies(limit: 11) { genres { id } } }`,
+			code:   CodeQueryTooComplex,
+		},
+		{
+			// genres have no limit, so ListSize of them is counted
+			name:   "lists without a limit use ListSize",
+			limits: Limits{MaxComplexity: 21, ListSize: 20},
+			query:  `{ movies(limit: 1) { genres { movies(limit: 1) { id } } } }`,
+			code:   CodeQueryTooComplex,
+		},
+		{
+			name:   "costs override fields",
+			limits: Limits{MaxComplexity: 5, Costs: map[string]int{"Query.genres": 10}},
+			query:  `{ genres { id } }`,
+			code:   CodeQueryTooComplex,
+		},
+		{
+			// 1 for movies and 100 for their genres rather than 1000
+			name:   "limits beyond maxLimit count as maxLimit",
+			limits: Limits{MaxComplexity: 101, ListSize: 20},
+			query:  `{ movies(limit: 1000) { genres { id } } }`,
+		},
+		{
+			name:   "nested lists can't overflow the cost",
+			limits: Limits{MaxComplexity: 1000},
+			query:  `{ movies(limit: 100) { genres { movies(limit: 100) { genres { movies(limit: 100) { genres { movies(limit: 100) { id } } } } } } } }`,
+			code:   CodeQueryTooComplex,
+		},
+		{
+			name:   "introspection is free",
+			limits: Limits{MaxDepth: 2, MaxComplexity: 1},
+			query:  `{ __schema { types { fields { type { name } } } } }`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := New(&countingRepo{}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			g.Limits = tt.limits
+
+			result, err := g.Request(tt.query, "", nil).Query(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			code := ""
+			if len(result.Errors) > 0 {
+				code, _ = result.Errors[0].Extensions["code"].(string)
+				if code == "" {
+					t.Fatalf("query failed: %v", result.Errors)
+				}
+			}
+			if code != tt.code {
+				t.Errorf("error code = %q, want %q", code, tt.code)
+			}
+		})
+	}
+}
+
+func TestLimitsTakeVariables(t *testing.T) {
+	g, err := New(&countingRepo{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Limits = Limits{MaxComplexity: 11, ListSize: 20}
+
+	query := `query($n: Int = 10) { movies(limit: $n) { genres { id } } }`
+	tests := []struct {
+		variables map[string]interface{}
+		refused   bool
+	}{
+		{nil, false},
+		{map[string]interface{}{"n": float64(10)}, false},
+		{map[string]interface{}{"n": float64(11)}, true},
+	}
+
+	for _, tt := range tests {
+		result, err := g.Request(query, "", tt.variables).Query(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if refused := result.HasErrors(); refused != tt.refused {
+			t.Errorf("variables %v: refused = %v, want %v", tt.variables, refused, tt.refused)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package graph
+
+import (
+	"context"
+	"sync"
+
+	"github.com/toluhikay/go-react/internal/models"
+	"github.com/toluhikay/go-react/internal/repository"
+)
+
+const loadersContextKey = contextKey("loaders")
+
+// fetches the values for a batch of keys at once
+type batchFunc[V any] func(ctx context.Context, keys []int) (map[int]V, error)
+
+// collects the keys resolvers ask for and fetches them in one batch. graphql-go
+// runs the thunks a resolver returns only after resolving every field at the
+// same level, so all the movies in a list have asked for their genres by the
+// time the first thunk fetches them. Results are cached for the request
+type loader[V any] struct {
+	// the request's, so a batch stops when the request times out
+	ctx     context.Context
+	mu      sync.Mutex
+	fetch   batchFunc[V]
+	results map[int]*loaded[V]
+	pending []int
+}
+
+type loaded[V any] struct {
+	value V
+	err   error
+}
+
+func newLoader[V any](ctx context.Context, fetch batchFunc[V]) *loader[V] {
+	return &loader[V]{
+		ctx:     ctx,
+		fetch:   fetch,
+		results: make(map[int]*loaded[V]),
+	}
+}
+
+// queue a key and return a thunk for graphql-go to call once the batch is ready
+func (l *loader[V]) load(key int) func() (interface{}, error) {
+	l.mu.Lock()
+	result, ok := l.results[key]
+	if !ok {
+		result = &loaded[V]{}
+		l.results[key] = result
+		l.pending = append(l.pending, key)
+	}
+	l.mu.Unlock()
+
+	return func() (interface{}, error) {
+		l.dispatch()
+		return result.value, result.err
+	}
+}
+
+// fetch every pending key, the first thunk called does it for the whole batch
+func (l *loader[V]) dispatch() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.pending) == 0 {
+		return
+	}
+
+	keys := l.pending
+	l.pending = nil
+
+	values, err := l.fetch(l.ctx, keys)
+	for _, key := range keys {
+		l.results[key].value = values[key]
+		l.results[key].err = err
+	}
+}
+
+// the loaders for one request, so nothing is cached between requests
+type loaders struct {
+	genresByMovie *loader[[]*models.Genre]
+	moviesByGenre *loader[[]*models.Movie]
+}
+
+func newLoaders(ctx context.Context, db repository.DatabaseRepo) *loaders {
+	return &loaders{
+		genresByMovie: newLoader(ctx, db.GenresByMovieIDs),
+		// every genre is fetched with as many movies as any limit allows, the
+		// resolvers cut them down to the limit each field asks for
+		moviesByGenre: newLoader(ctx, func(ctx context.Context, ids []int) (map[int][]*models.Movie, error) {
+			return db.MoviesByGenreIDs(ctx, ids, maxLimit)
+		}),
+	}
+}
+
+func loadersFromContext(ctx context.Context) *loaders {
+	l, _ := ctx.Value(loadersContextKey).(*loaders)
+	return l
+}
@@ -0,0 +1,148 @@
+package graph
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	"github.com/toluhikay/go-react/internal/models"
+	"github.com/toluhikay/go-react/internal/repository"
+)
+
+// a repository with two movies that are both in two genres, counting the
+// batch lookups and the keys each was asked for
+type countingRepo struct {
+	repository.DatabaseRepo
+
+	mu             sync.Mutex
+	genreBatches   [][]int
+	movieBatches   [][]int
+	listMovieCalls int
+}
+
+func (r *countingRepo) ListMovies(ctx context.Context, query models.MovieQuery) ([]*models.Movie, error) {
+	r.mu.Lock()
+	r.listMovieCalls++
+	r.mu.Unlock()
+	return []*models.Movie{{ID: 1, Title: "Highlander"}, {ID: 2, Title: "Raiders of the Lost Ark"}}, nil
+}
+
+func (r *countingRepo) GenresByMovieIDs(ctx context.Context, ids []int) (map[int][]*models.Genre, error) {
+	r.mu.Lock()
+	r.genreBatches = append(r.genreBatches, sortedKeys(ids))
+	r.mu.Unlock()
+
+	genres := make(map[int][]*models.Genre, len(ids))
+	for _, id := range ids {
+		genres[id] = []*models.Genre{{ID: 5, Genre: "Action"}, {ID: 11, Genre: "Adventure"}}
+	}
+	return genres, nil
+}
+
+func (r *countingRepo) MoviesByGenreIDs(ctx context.Context, ids []int, limit int) (map[int][]*models.Movie, error) {
+	r.mu.Lock()
+	r.movieBatches = append(r.movieBatches, sortedKeys(ids))
+	r.mu.Unlock()
+
+	movies := make(map[int][]*models.Movie, len(ids))
+	for _, id := range ids {
+		movies[id] = []*models.Movie{{ID: 1, Title: "Highlander"}, {ID: 2, Title: "Raiders of the Lost Ark"}}
+	}
+	return movies, nil
+}
+
+func sortedKeys(ids []int) []int {
+	keys := append([]int(nil), ids...)
+	sort.Ints(keys)
+	return keys
+}
+
+func runQuery(t *testing.T, db repository.DatabaseRepo, query string) *graphql.Result {
+	t.Helper()
+
+	g, err := New(db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := g.Request(query, "", nil).Query(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.HasErrors() {
+		t.Fatalf("query failed: %v", result.Errors)
+	}
+	return result
+}
+
+func TestLoaderBatchesEachLevel(t *testing.T) {
+	db := &countingRepo{}
+	runQuery(t, db, `{ movies { id genres { id movies { id } } } }`)
+
+	if db.listMovieCalls != 1 {
+		t.Errorf("ListMovies called %d times, want 1", db.listMovieCalls)
+	}
+
+	// both movies' genres in one batch
+	if len(db.genreBatches) != 1 {
+		t.Fatalf("GenresByMovieIDs called %d times, want 1: %v", len(db.genreBatches), db.genreBatches)
+	}
+	if got := db.genreBatches[0]; len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Errorf("GenresByMovieIDs keys = %v, want [1 2]", got)
+	}
+
+	// each genre appears under both movies but is only asked for once
+	if len(db.movieBatches) != 1 {
+		t.Fatalf("MoviesByGenreIDs called %d times, want 1: %v", len(db.movieBatches), db.movieBatches)
+	}
+	if got := db.movieBatches[0]; len(got) != 2 || got[0] != 5 || got[1] != 11 {
+		t.Errorf("MoviesByGenreIDs keys = %v, want [5 11]", got)
+	}
+}
+
+func TestLoaderCachesRepeatedKeys(t *testing.T) {
+	db := &countingRepo{}
+
+	// genre 5 is fetched at the top and asked for again two levels down
+	runQuery(t, db, `{
+		action: moviesByGenre(genre_id: 5) { id genres { id movies { id } } }
+		again: moviesByGenre(genre_id: 5) { id }
+	}`)
+
+	if len(db.genreBatches) != 1 {
+		t.Errorf("GenresByMovieIDs called %d times, want 1: %v", len(db.genreBatches), db.genreBatches)
+	}
+
+	// the first batch has genre 5 for both fields, the second only genre 11
+	// because 5 is already cached
+	if len(db.movieBatches) != 2 {
+		t.Fatalf("MoviesByGenreIDs called %d times, want 2: %v", len(db.movieBatches), db.movieBatches)
+	}
+	if got := db.movieBatches[0]; len(got) != 1 || got[0] != 5 {
+		t.Errorf("first MoviesByGenreIDs keys = %v, want [5]", got)
+	}
+	if got := db.movieBatches[1]; len(got) != 1 || got[0] != 11 {
+		t.Errorf("second MoviesByGenreIDs keys = %v, want [11]", got)
+	}
+}
+
+func TestLoadersAreNotSharedBetweenRequests(t *testing.T) {
+	db := &countingRepo{}
+	runQuery(t, db, `{ moviesByGenre(genre_id: 5) { id } }`)
+	runQuery(t, db, `{ moviesByGenre(genre_id: 5) { id } }`)
+
+	if len(db.movieBatches) != 2 {
+		t.Errorf("MoviesByGenreIDs called %d times across two requests, want 2", len(db.movieBatches))
+	}
+}
+
+func TestGenreMoviesLimit(t *testing.T) {
+	result := runQuery(t, &countingRepo{}, `{ moviesByGenre(genre_id: 5, limit: 1) { id } }`)
+
+	movies := result.Data.(map[string]interface{})["moviesByGenre"].([]interface{})
+	if len(movies) != 1 {
+		t.Errorf("moviesByGenre(limit: 1) returned %d movies, want 1", len(movies))
+	}
+}
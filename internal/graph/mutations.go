@@ -0,0 +1,307 @@
+package graph
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/graphql-go/graphql"
+	"github.com/toluhikay/go-react/internal/models"
+)
+
+// lets whoever serves the graph react to changes made by mutations, e.g. to
+// audit them. Every hook is optional
+type Hooks struct {
+	// fill in a new movie before it is saved, like looking up its poster
+	PrepareMovie func(movie *models.Movie)
+	// a movie was created, updated or deleted, called once its genres are
+	// saved. before is nil for a new movie and after is nil for a deleted one
+	MovieChanged func(before, after *models.Movie)
+	// a movie's genres were replaced
+	GenresChanged func(movieID int, before []*models.Genre, after []int)
+}
+
+// the hooks of the request being run, the resolvers belong to the graph New
+// built so they can't read them off a copy made by Request
+func hooksFromContext(ctx context.Context) Hooks {
+	hooks, _ := ctx.Value(hooksContextKey).(Hooks)
+	return hooks
+}
+
+var movieInputType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name:        "MovieInput",
+	Description: "The fields of a movie, on update only the fields sent are changed",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"title":        &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"description":  &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"release_date": &graphql.InputObjectFieldConfig{Type: graphql.DateTime},
+		"runtime":      &graphql.InputObjectFieldConfig{Type: graphql.Int},
+		"mpaa_rating":  &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"genres":       &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.Int))},
+	},
+})
+
+func (g *Graph) mutationType() *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createMovie": &graphql.Field{
+				Type: graphql.NewNonNull(g.movieType),
+				Args: graphql.FieldConfigArgument{
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(movieInputType)},
+				},
+				Resolve: g.createMovie,
+			},
+			"updateMovie": &graphql.Field{
+				Type: graphql.NewNonNull(g.movieType),
+				Args: graphql.FieldConfigArgument{
+					"id":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(movieInputType)},
+				},
+				Resolve: g.updateMovie,
+			},
+			"deleteMovie": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.Int),
+				Description: "Delete a movie, returning its id",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: g.deleteMovie,
+			},
+			"setMovieGenres": &graphql.Field{
+				Type: graphql.NewNonNull(g.movieType),
+				Args: graphql.FieldConfigArgument{
+					"id":        &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"genre_ids": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.Int)))},
+				},
+				Resolve: g.setMovieGenres,
+			},
+		},
+	})
+}
+
+func (g *Graph) createMovie(p graphql.ResolveParams) (interface{}, error) {
+	viewer, err := requirePermission(p.Context, models.PermMoviesWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	input := p.Args["input"].(map[string]interface{})
+
+	var movie models.Movie
+	applyMovieInput(&movie, input)
+	err = validateMovie(&movie)
+	if err != nil {
+		return nil, err
+	}
+
+	hooks := hooksFromContext(p.Context)
+	if hooks.PrepareMovie != nil {
+		hooks.PrepareMovie(&movie)
+	}
+	movie.CreatedAt = time.Now()
+	movie.UpdatedAt = time.Now()
+	movie.CreatedBy = viewer.UserID
+	movie.UpdatedBy = viewer.UserID
+
+	movie.ID, err = g.DB.InsertMovie(movie)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := input["genres"]; ok {
+		err = g.replaceGenres(p.Context, movie.ID, nil, intsArg(input, "genres"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// read it back so the hooks get the genres as saved
+	saved, err := g.DB.GetOneMovie(movie.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if hooks.MovieChanged != nil {
+		hooks.MovieChanged(nil, saved)
+	}
+
+	return saved, nil
+}
+
+func (g *Graph) updateMovie(p graphql.ResolveParams) (interface{}, error) {
+	movie, err := g.editableMovie(p.Context, p.Args["id"].(int))
+	if err != nil {
+		return nil, err
+	}
+
+	input := p.Args["input"].(map[string]interface{})
+
+	before := *movie
+	applyMovieInput(movie, input)
+	err = validateMovie(movie)
+	if err != nil {
+		return nil, err
+	}
+
+	viewer, _ := viewerFromContext(p.Context)
+	movie.UpdatedAt = time.Now()
+	movie.UpdatedBy = viewer.UserID
+
+	err = g.DB.UpdateMovie(*movie)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := input["genres"]; ok {
+		err = g.replaceGenres(p.Context, movie.ID, before.Genres, intsArg(input, "genres"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the genres movie was loaded with may have just been replaced, read it
+	// back so the hooks get the ones saved
+	after, err := g.DB.GetOneMovie(movie.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if hooks := hooksFromContext(p.Context); hooks.MovieChanged != nil {
+		hooks.MovieChanged(&before, after)
+	}
+
+	return after, nil
+}
+
+func (g *Graph) deleteMovie(p graphql.ResolveParams) (interface{}, error) {
+	_, err := requirePermission(p.Context, models.PermMoviesDelete)
+	if err != nil {
+		return nil, err
+	}
+
+	id := p.Args["id"].(int)
+
+	movie, err := g.findMovie(id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = g.DB.DeleteMovie(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, newError(CodeNotFound, "movie not found")
+		}
+		return nil, err
+	}
+
+	if hooks := hooksFromContext(p.Context); hooks.MovieChanged != nil {
+		hooks.MovieChanged(movie, nil)
+	}
+
+	return id, nil
+}
+
+func (g *Graph) setMovieGenres(p graphql.ResolveParams) (interface{}, error) {
+	movie, err := g.editableMovie(p.Context, p.Args["id"].(int))
+	if err != nil {
+		return nil, err
+	}
+
+	err = g.replaceGenres(p.Context, movie.ID, movie.Genres, intsArg(p.Args, "genre_ids"))
+	if err != nil {
+		return nil, err
+	}
+
+	return g.DB.GetOneMovie(movie.ID)
+}
+
+// get a movie the viewer may edit. Editors can only change the movies they
+// added themselves
+func (g *Graph) editableMovie(ctx context.Context, id int) (*models.Movie, error) {
+	viewer, err := requirePermission(ctx, models.PermMoviesWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	movie, err := g.findMovie(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if movie.CreatedBy != viewer.UserID && !viewer.HasPermission(models.PermMoviesWriteAny) {
+		return nil, newError(CodeForbidden, "you can only edit movies you created")
+	}
+
+	return movie, nil
+}
+
+func (g *Graph) findMovie(id int) (*models.Movie, error) {
+	movie, err := g.DB.GetOneMovie(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, newError(CodeNotFound, "movie not found")
+		}
+		return nil, err
+	}
+	return movie, nil
+}
+
+func (g *Graph) replaceGenres(ctx context.Context, movieID int, current []*models.Genre, genreIDs []int) error {
+	err := g.DB.UpdateMovieGenre(movieID, genreIDs)
+	if err != nil {
+		return err
+	}
+
+	if hooks := hooksFromContext(ctx); hooks.GenresChanged != nil {
+		hooks.GenresChanged(movieID, current, genreIDs)
+	}
+
+	return nil
+}
+
+// copy the fields that were sent onto the movie
+func applyMovieInput(movie *models.Movie, input map[string]interface{}) {
+	if title, ok := input["title"].(string); ok {
+		movie.Title = strings.TrimSpace(title)
+	}
+	if description, ok := input["description"].(string); ok {
+		movie.Description = strings.TrimSpace(description)
+	}
+	if releaseDate, ok := input["release_date"].(time.Time); ok {
+		movie.ReleaseDate = releaseDate
+	}
+	if runtime, ok := input["runtime"].(int); ok {
+		movie.RunTime = runtime
+	}
+	if rating, ok := input["mpaa_rating"].(string); ok {
+		movie.MPAARating = strings.TrimSpace(rating)
+	}
+}
+
+// check a movie fits the columns it is stored in, each problem is reported
+// against the input field it came from
+func validateMovie(movie *models.Movie) error {
+	problems := inputErrors{}
+
+	if movie.Title == "" {
+		problems.add("title", "title is required")
+	}
+	if utf8.RuneCountInString(movie.Title) > 512 {
+		problems.add("title", "title must be at most 512 characters")
+	}
+	if movie.ReleaseDate.IsZero() {
+		problems.add("release_date", "release_date is required")
+	}
+	if movie.RunTime < 0 {
+		problems.add("runtime", "runtime can't be negative")
+	}
+	if utf8.RuneCountInString(movie.MPAARating) > 10 {
+		problems.add("mpaa_rating", "mpaa_rating must be at most 10 characters")
+	}
+
+	return problems.err()
+}
@@ -0,0 +1,169 @@
+package graph
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/graphql-go/graphql/gqlerrors"
+)
+
+// error codes for persisted queries, the not found message is the one apollo
+// clients look for before sending the full query
+const (
+	CodePersistedQueryNotFound     = "PERSISTED_QUERY_NOT_FOUND"
+	CodePersistedQueryNotSupported = "PERSISTED_QUERY_NOT_SUPPORTED"
+	CodePersistedQueryNotAllowed   = "PERSISTED_QUERY_NOT_ALLOWED"
+	CodePersistedQueryMismatch     = "PERSISTED_QUERY_HASH_MISMATCH"
+)
+
+// queries kept by their sha256 hash so clients can send the hash instead of
+// the query. With automatic persisted queries a client sends a hash, and on a
+// miss sends the query with its hash to register it. With an allowlist only
+// the queries from a manifest can run at all
+type PersistedQueries struct {
+	mu      sync.RWMutex
+	queries map[string]string
+	// how many queries are registered before old ones are dropped, 0 for no limit
+	max int
+	// only run queries from the manifest, nothing is registered on a miss
+	allowlist bool
+}
+
+// a store queries are registered in as clients send them
+func NewPersistedQueries(max int) *PersistedQueries {
+	return &PersistedQueries{
+		queries: make(map[string]string),
+		max:     max,
+	}
+}
+
+// the operations in an apollo persisted query manifest, the id of each is the
+// sha256 hash of its body
+type manifest struct {
+	Format     string `json:"format"`
+	Version    int    `json:"version"`
+	Operations []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+		Type string `json:"type"`
+		Body string `json:"body"`
+	} `json:"operations"`
+}
+
+// an allowlist of the queries in a manifest file, no other query will run
+func LoadManifest(path string) (*PersistedQueries, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m manifest
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		return nil, fmt.Errorf("reading persisted query manifest: %w", err)
+	}
+	if m.Format != "apollo-persisted-query-manifest" || m.Version != 1 {
+		return nil, fmt.Errorf("persisted query manifest must be an apollo-persisted-query-manifest, version 1")
+	}
+
+	pq := &PersistedQueries{
+		queries:   make(map[string]string, len(m.Operations)),
+		allowlist: true,
+	}
+	for _, op := range m.Operations {
+		if hashQuery(op.Body) != op.ID {
+			return nil, fmt.Errorf("persisted query %q has an id that isn't the sha256 hash of its body", op.Name)
+		}
+		pq.queries[op.ID] = op.Body
+	}
+
+	return pq, nil
+}
+
+func (pq *PersistedQueries) Len() int {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+	return len(pq.queries)
+}
+
+// work out the query to run from the query and hash a request was sent with.
+// register is true when the query should be stored once it is known to be valid
+func (pq *PersistedQueries) resolve(query, hash string) (string, bool, *gqlerrors.FormattedError) {
+	if query != "" && hash != "" && hashQuery(query) != hash {
+		err := requestError(CodePersistedQueryMismatch, "provided sha does not match query")
+		return "", false, &err
+	}
+
+	if pq == nil {
+		if query == "" && hash != "" {
+			err := requestError(CodePersistedQueryNotSupported, "PersistedQueryNotSupported")
+			return "", false, &err
+		}
+		return query, false, nil
+	}
+
+	if query == "" {
+		if hash == "" {
+			return "", false, nil
+		}
+
+		pq.mu.RLock()
+		stored, ok := pq.queries[hash]
+		pq.mu.RUnlock()
+
+		if ok {
+			return stored, false, nil
+		}
+		if pq.allowlist {
+			err := requestError(CodePersistedQueryNotAllowed, "only persisted queries are allowed")
+			return "", false, &err
+		}
+		err := requestError(CodePersistedQueryNotFound, "PersistedQueryNotFound")
+		return "", false, &err
+	}
+
+	if pq.allowlist {
+		pq.mu.RLock()
+		_, ok := pq.queries[hashQuery(query)]
+		pq.mu.RUnlock()
+
+		if !ok {
+			err := requestError(CodePersistedQueryNotAllowed, "only persisted queries are allowed")
+			return "", false, &err
+		}
+		return query, false, nil
+	}
+
+	// only queries sent with their hash are registered, that is the client
+	// asking for it
+	return query, hash != "", nil
+}
+
+// store a query by its hash. When the store is full an arbitrary query is
+// dropped, a client sending its hash gets a miss and registers it again
+func (pq *PersistedQueries) register(query string) {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	hash := hashQuery(query)
+	if _, ok := pq.queries[hash]; ok {
+		return
+	}
+
+	if pq.max > 0 && len(pq.queries) >= pq.max {
+		for h := range pq.queries {
+			delete(pq.queries, h)
+			break
+		}
+	}
+	pq.queries[hash] = query
+}
+
+func hashQuery(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
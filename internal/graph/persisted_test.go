@@ -0,0 +1,183 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const persistedQuery = `{ movies { id } }`
+
+// run a request with the query and hash given, returning the error code it
+// was refused with or "" when it ran
+func persistedRequest(t *testing.T, pq *PersistedQueries, query, hash string) string {
+	t.Helper()
+
+	g, err := New(&countingRepo{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Persisted = pq
+
+	req := g.Request(query, "", nil)
+	req.QueryHash = hash
+	result, err := req.Query(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Errors) == 0 {
+		return ""
+	}
+	code, _ := result.Errors[0].Extensions["code"].(string)
+	if code == "" {
+		t.Fatalf("query failed: %v", result.Errors)
+	}
+	return code
+}
+
+func TestAutomaticPersistedQueries(t *testing.T) {
+	hash := hashQuery(persistedQuery)
+
+	tests := []struct {
+		name  string
+		pq    *PersistedQueries
+		query string
+		hash  string
+		code  string
+	}{
+		{"hash mismatch", NewPersistedQueries(0), persistedQuery, hashQuery("{ genres { id } }"), CodePersistedQueryMismatch},
+		{"unknown hash", NewPersistedQueries(0), "", hash, CodePersistedQueryNotFound},
+		{"query and hash", NewPersistedQueries(0), persistedQuery, hash, ""},
+		{"query alone", NewPersistedQueries(0), persistedQuery, "", ""},
+		{"hash with persisted queries off", nil, "", hash, CodePersistedQueryNotSupported},
+		{"hash mismatch with persisted queries off", nil, persistedQuery, "abc", CodePersistedQueryMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := persistedRequest(t, tt.pq, tt.query, tt.hash); code != tt.code {
+				t.Errorf("error code = %q, want %q", code, tt.code)
+			}
+		})
+	}
+}
+
+func TestAutomaticPersistedQueriesRegister(t *testing.T) {
+	pq := NewPersistedQueries(1)
+	hash := hashQuery(persistedQuery)
+
+	// the query sent with its hash registers it, then the hash alone runs it
+	if code := persistedRequest(t, pq, persistedQuery, hash); code != "" {
+		t.Fatalf("registering failed with %s", code)
+	}
+	if code := persistedRequest(t, pq, "", hash); code != "" {
+		t.Errorf("hash after registering failed with %s", code)
+	}
+
+	// invalid queries aren't kept
+	bad := `{ nope }`
+	g, err := New(&countingRepo{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Persisted = pq
+	req := g.Request(bad, "", nil)
+	req.QueryHash = hashQuery(bad)
+	if result, err := req.Query(context.Background()); err != nil || !result.HasErrors() {
+		t.Fatalf("invalid query ran: %v", err)
+	}
+	if code := persistedRequest(t, pq, "", hashQuery(bad)); code != CodePersistedQueryNotFound {
+		t.Errorf("invalid query: error code = %q, want %q", code, CodePersistedQueryNotFound)
+	}
+
+	// a full store drops a query to make room
+	other := `{ moviesByGenre(genre_id: 5) { id } }`
+	persistedRequest(t, pq, other, hashQuery(other))
+	if n := pq.Len(); n != 1 {
+		t.Errorf("store holds %d queries, want at most 1", n)
+	}
+}
+
+func TestManifestAllowlist(t *testing.T) {
+	allowed := persistedQuery
+	m := map[string]interface{}{
+		"format":  "apollo-persisted-query-manifest",
+		"version": 1,
+		"operations": []map[string]string{
+			{"id": hashQuery(allowed), "name": "Movies", "type": "query", "body": allowed},
+		},
+	}
+	pq, err := LoadManifest(writeManifest(t, m))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := `{ moviesByGenre(genre_id: 5) { id } }`
+	tests := []struct {
+		name  string
+		query string
+		hash  string
+		code  string
+	}{
+		{"allowed hash", "", hashQuery(allowed), ""},
+		{"allowed query", allowed, "", ""},
+		{"other query", other, "", CodePersistedQueryNotAllowed},
+		{"other query with its hash", other, hashQuery(other), CodePersistedQueryNotAllowed},
+		{"unknown hash", "", hashQuery(other), CodePersistedQueryNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := persistedRequest(t, pq, tt.query, tt.hash); code != tt.code {
+				t.Errorf("error code = %q, want %q", code, tt.code)
+			}
+		})
+	}
+
+	// nothing sent is registered with an allowlist
+	if n := pq.Len(); n != 1 {
+		t.Errorf("allowlist holds %d queries, want 1", n)
+	}
+}
+
+func TestLoadManifestRejects(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest map[string]interface{}
+	}{
+		{"wrong format", map[string]interface{}{"format": "other", "version": 1}},
+		{"wrong version", map[string]interface{}{"format": "apollo-persisted-query-manifest", "version": 2}},
+		{"id that isn't the hash", map[string]interface{}{
+			"format":  "apollo-persisted-query-manifest",
+			"version": 1,
+			"operations": []map[string]string{
+				{"id": "abc", "name": "Movies", "type": "query", "body": persistedQuery},
+			},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadManifest(writeManifest(t, tt.manifest)); err == nil {
+				t.Error("LoadManifest accepted the manifest")
+			}
+		})
+	}
+}
+
+func writeManifest(t *testing.T, m map[string]interface{}) string {
+	t.Helper()
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "manifest.json")
+	err = os.WriteFile(path, data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
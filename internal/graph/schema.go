@@ -0,0 +1,261 @@
+package graph
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/toluhikay/go-react/internal/models"
+)
+
+var movieSortEnum = graphql.NewEnum(graphql.EnumConfig{
+	Name: "MovieSort",
+	Values: graphql.EnumValueConfigMap{
+		"TITLE":        {Value: models.MovieSortTitle},
+		"RELEASE_DATE": {Value: models.MovieSortReleaseDate},
+		"RUNTIME":      {Value: models.MovieSortRuntime},
+		"CREATED_AT":   {Value: models.MovieSortCreatedAt},
+	},
+})
+
+// the movie and genre types refer to each other, so their fields are thunks
+// resolved once both exist
+func (g *Graph) types() (movieType, genreType *graphql.Object) {
+	genreType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Genre",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id":    &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+				"genre": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"movies": &graphql.Field{
+					Type: graphql.NewList(graphql.NewNonNull(movieType)),
+					Args: graphql.FieldConfigArgument{
+						"limit": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: defaultLimit},
+					},
+					Resolve: g.genreMovies,
+				},
+			}
+		}),
+	})
+
+	movieType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Movie",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id":           &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+				"title":        &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"description":  &graphql.Field{Type: graphql.String},
+				"release_date": &graphql.Field{Type: graphql.DateTime},
+				"runtime":      &graphql.Field{Type: graphql.Int},
+				"mpaa_rating":  &graphql.Field{Type: graphql.String},
+				"image":        &graphql.Field{Type: graphql.String},
+				"genres": &graphql.Field{
+					Type:    graphql.NewList(graphql.NewNonNull(genreType)),
+					Resolve: g.movieGenres,
+				},
+			}
+		}),
+	})
+
+	return movieType, genreType
+}
+
+func (g *Graph) queryType() *graphql.Object {
+	movieType, genreType := g.movieType, g.genreType
+
+	searchResultType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "SearchResult",
+		Fields: graphql.Fields{
+			"movie": &graphql.Field{
+				Type: graphql.NewNonNull(movieType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return &p.Source.(*models.MovieSearchResult).Movie, nil
+				},
+			},
+			"rank":            &graphql.Field{Type: graphql.Float},
+			"title_highlight": &graphql.Field{Type: graphql.String},
+			"snippet":         &graphql.Field{Type: graphql.String},
+		},
+	})
+
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"movies": &graphql.Field{
+				Type:        graphql.NewList(graphql.NewNonNull(movieType)),
+				Description: "A page of the catalogue",
+				Args: graphql.FieldConfigArgument{
+					"sort":      &graphql.ArgumentConfig{Type: movieSortEnum, DefaultValue: models.MovieSortTitle},
+					"desc":      &graphql.ArgumentConfig{Type: graphql.Boolean, DefaultValue: false},
+					"limit":     &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: defaultLimit},
+					"ratings":   &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+					"genres":    &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.Int))},
+					"year_from": &graphql.ArgumentConfig{Type: graphql.Int},
+					"year_to":   &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: g.listMovies,
+			},
+			"movie": &graphql.Field{
+				Type:        movieType,
+				Description: "Get a movie by id",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: g.getMovie,
+			},
+			"search": &graphql.Field{
+				Type:        graphql.NewList(graphql.NewNonNull(searchResultType)),
+				Description: "Search the catalogue by title and description",
+				Args: graphql.FieldConfigArgument{
+					"query":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"limit":   &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: defaultLimit},
+					"offset":  &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+					"ratings": &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+					"genres":  &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.Int))},
+				},
+				Resolve: g.searchMovies,
+			},
+			"moviesByGenre": &graphql.Field{
+				Type:        graphql.NewList(graphql.NewNonNull(movieType)),
+				Description: "The movies in a genre, by title",
+				Args: graphql.FieldConfigArgument{
+					"genre_id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"limit":    &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: defaultLimit},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return moviesInGenre(p, p.Args["genre_id"].(int))
+				},
+			},
+			"genres": &graphql.Field{
+				Type:        graphql.NewList(graphql.NewNonNull(genreType)),
+				Description: "Every genre",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return g.DB.AllGenres()
+				},
+			},
+		},
+	})
+}
+
+func (g *Graph) listMovies(p graphql.ResolveParams) (interface{}, error) {
+	limit, err := limitArg(p.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	q := models.MovieQuery{
+		Sort:    p.Args["sort"].(string),
+		Desc:    p.Args["desc"].(bool),
+		Limit:   limit,
+		Ratings: stringsArg(p.Args, "ratings"),
+		Genres:  intsArg(p.Args, "genres"),
+	}
+	q.YearFrom, _ = p.Args["year_from"].(int)
+	q.YearTo, _ = p.Args["year_to"].(int)
+
+	return g.DB.ListMovies(p.Context, q)
+}
+
+func (g *Graph) getMovie(p graphql.ResolveParams) (interface{}, error) {
+	movie, err := g.DB.GetOneMovie(p.Args["id"].(int))
+	if err != nil {
+		// an unknown id is null rather than an error
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return movie, nil
+}
+
+func (g *Graph) searchMovies(p graphql.ResolveParams) (interface{}, error) {
+	limit, err := limitArg(p.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	offset := p.Args["offset"].(int)
+	if offset < 0 {
+		return nil, errors.New("offset can't be negative")
+	}
+
+	search := models.MovieSearch{
+		Query:   strings.TrimSpace(p.Args["query"].(string)),
+		Ratings: stringsArg(p.Args, "ratings"),
+		Genres:  intsArg(p.Args, "genres"),
+		Limit:   limit,
+		Offset:  offset,
+	}
+	if search.Query == "" {
+		return nil, errors.New("query is required")
+	}
+
+	results, _, err := g.DB.SearchMovies(p.Context, search)
+	return results, err
+}
+
+// a movie's genres, either loaded with the movie when it was fetched by id or
+// batched with the genres of every other movie in the response
+func (g *Graph) movieGenres(p graphql.ResolveParams) (interface{}, error) {
+	movie := p.Source.(*models.Movie)
+	if movie.Genres != nil {
+		return movie.Genres, nil
+	}
+	return loadersFromContext(p.Context).genresByMovie.load(movie.ID), nil
+}
+
+func (g *Graph) genreMovies(p graphql.ResolveParams) (interface{}, error) {
+	return moviesInGenre(p, p.Source.(*models.Genre).ID)
+}
+
+// the first of a genre's movies up to the field's limit, batched with every
+// other genre in the response
+func moviesInGenre(p graphql.ResolveParams, genreID int) (interface{}, error) {
+	limit, err := limitArg(p.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	load := loadersFromContext(p.Context).moviesByGenre.load(genreID)
+	return func() (interface{}, error) {
+		value, err := load()
+		if err != nil {
+			return nil, err
+		}
+		movies, _ := value.([]*models.Movie)
+		if len(movies) > limit {
+			movies = movies[:limit]
+		}
+		return movies, nil
+	}, nil
+}
+
+func limitArg(args map[string]interface{}) (int, error) {
+	limit := args["limit"].(int)
+	if limit < 1 {
+		return 0, errors.New("limit must be a positive integer")
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	return limit, nil
+}
+
+func stringsArg(args map[string]interface{}, key string) []string {
+	var list []string
+	values, _ := args[key].([]interface{})
+	for _, v := range values {
+		list = append(list, v.(string))
+	}
+	return list
+}
+
+func intsArg(args map[string]interface{}, key string) []int {
+	var list []int
+	values, _ := args[key].([]interface{})
+	for _, v := range values {
+		list = append(list, v.(int))
+	}
+	return list
+}
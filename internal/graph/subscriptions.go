@@ -0,0 +1,138 @@
+package graph
+
+import (
+	"context"
+	"errors"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/toluhikay/go-react/internal/events"
+	"github.com/toluhikay/go-react/internal/models"
+)
+
+func (g *Graph) subscriptionType() *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "Subscription",
+		Fields: graphql.Fields{
+			"movieCreated": g.movieEvent(events.MovieCreated, "A movie was added to the catalogue"),
+			"movieUpdated": g.movieEvent(events.MovieUpdated, "A movie was changed"),
+			"movieDeleted": g.movieEvent(events.MovieDeleted, "A movie was deleted, as it was before it went"),
+		},
+	})
+}
+
+// a field that sends the movie of every event of one type. Subscribe is only
+// called once when the subscription starts, Resolve is called for each event
+func (g *Graph) movieEvent(eventType, description string) *graphql.Field {
+	return &graphql.Field{
+		Type:        graphql.NewNonNull(g.movieType),
+		Description: description,
+		Subscribe: func(p graphql.ResolveParams) (interface{}, error) {
+			_, err := requireReadPermission(p.Context, models.PermMoviesRead)
+			if err != nil {
+				return nil, err
+			}
+
+			ch, cancel := g.bus.Subscribe(eventType)
+			go func() {
+				<-p.Context.Done()
+				cancel()
+			}()
+			return ch, nil
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return p.Source.(events.Event).Payload, nil
+		},
+	}
+}
+
+// start a subscription, sending a result for every event until ctx is done,
+// when the channel is closed. Queries and mutations can be sent this way too,
+// they send a single result. Errors that stop the request from starting are
+// returned rather than sent, the error is for a request that can't be run at all
+func (g *Graph) Subscribe(ctx context.Context) (<-chan *graphql.Result, []gqlerrors.FormattedError, error) {
+	doc, op, errs, err := g.prepare()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(errs) > 0 {
+		return nil, errs, nil
+	}
+
+	results := make(chan *graphql.Result, 1)
+
+	if op == nil || op.Operation != ast.OperationTypeSubscription {
+		results <- g.execute(ctx, doc, op, nil)
+		close(results)
+		return results, nil, nil
+	}
+
+	// a subscription listens for one thing, validation has already checked
+	// there is exactly one root field but it may be inside a fragment
+	field := rootField(doc, op.SelectionSet)
+	if field == nil {
+		return nil, []gqlerrors.FormattedError{
+			requestError(CodeBadUserInput, "a subscription must select a single field"),
+		}, nil
+	}
+
+	def, ok := g.schema.SubscriptionType().Fields()[field.Name.Value]
+	if !ok || def.Subscribe == nil {
+		return nil, []gqlerrors.FormattedError{
+			requestError(CodeBadUserInput, "unknown subscription "+field.Name.Value),
+		}, nil
+	}
+
+	source, err := def.Subscribe(graphql.ResolveParams{Context: ctx})
+	if err != nil {
+		return nil, []gqlerrors.FormattedError{formatError(err)}, nil
+	}
+	stream := source.(<-chan events.Event)
+
+	go func() {
+		defer close(results)
+		for e := range stream {
+			result := g.execute(ctx, doc, op, e)
+			select {
+			case results <- result:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return results, nil, nil
+}
+
+// the single field a subscription selects, looking through fragments
+func rootField(doc *ast.Document, set *ast.SelectionSet) *ast.Field {
+	if set == nil || len(set.Selections) != 1 {
+		return nil
+	}
+
+	switch s := set.Selections[0].(type) {
+	case *ast.Field:
+		return s
+	case *ast.InlineFragment:
+		return rootField(doc, s.SelectionSet)
+	case *ast.FragmentSpread:
+		for _, def := range doc.Definitions {
+			if fragment, ok := def.(*ast.FragmentDefinition); ok && fragment.Name.Value == s.Name.Value {
+				return rootField(doc, fragment.SelectionSet)
+			}
+		}
+	}
+	return nil
+}
+
+// an error from a resolver as it would be sent back, keeping its code
+func formatError(err error) gqlerrors.FormattedError {
+	formatted := gqlerrors.FormatError(err)
+
+	var e *Error
+	if errors.As(err, &e) {
+		formatted.Extensions = e.Extensions()
+	}
+	return formatted
+}
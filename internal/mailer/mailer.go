@@ -0,0 +1,88 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// a single plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// anything that can deliver a message, so the app does not care how mail goes out
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// send mail through an smtp server
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	Sender   string
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	addr := fmt.Sprintf("%s:%d", m.Host, m.Port)
+
+	// only authenticate when the server needs it, local relays usually don't
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", m.Sender)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(msg.Body)
+
+	// the envelope needs the bare address, not the display name
+	from, err := mail.ParseAddress(m.Sender)
+	if err != nil {
+		return err
+	}
+
+	return smtp.SendMail(addr, auth, from.Address, []string{msg.To}, []byte(b.String()))
+}
+
+// write mail to a file, or the log when no file is set, for local development
+type LogMailer struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (m *LogMailer) Send(msg Message) error {
+	out := fmt.Sprintf("To: %s\nSubject: %s\n\n%s\n----\n", msg.To, msg.Subject, msg.Body)
+
+	if m.Path == "" {
+		log.Print("mail: ", out)
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(out)
+	return err
+}
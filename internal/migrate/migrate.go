@@ -0,0 +1,392 @@
+// Package migrate applies numbered up and down sql migrations to postgres,
+// recording which are applied in the schema_migrations table.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// held while migrating so instances starting together don't both apply the
+// same migration, the others wait and then find nothing left to do
+const lockKey = 7239410385
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	// empty when the migration can't be reverted
+	Down string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// a migration and whether the database has it
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+	// applied to the database but not one of the migrations known here, it
+	// was applied by a newer build
+	Unknown bool
+}
+
+type Migrator struct {
+	DB         *sql.DB
+	migrations []Migration
+}
+
+// read the migrations in the root of fsys
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	files, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		match := fileName.FindStringSubmatch(f.Name())
+		if match == nil {
+			continue
+		}
+
+		version, err := strconv.Atoi(match[1])
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("migration %s has an invalid version", f.Name())
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migrations %04d_%s and %s share a version", version, m.Name, f.Name())
+		}
+
+		body, err := fs.ReadFile(fsys, f.Name())
+		if err != nil {
+			return nil, err
+		}
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrator := &Migrator{DB: db}
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %s has no up migration", m)
+		}
+		migrator.migrations = append(migrator.migrations, *m)
+	}
+	sort.Slice(migrator.migrations, func(i, j int) bool {
+		return migrator.migrations[i].Version < migrator.migrations[j].Version
+	})
+
+	return migrator, nil
+}
+
+// the version of the newest migration, 0 when there are none
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// a migration to run as part of a change
+type Step struct {
+	Migration
+	// run the down migration rather than the up one
+	Revert bool
+	// record the migration as applied without running it
+	RecordOnly bool
+}
+
+// apply every migration that hasn't been applied yet
+func (m *Migrator) Up(ctx context.Context) ([]Step, error) {
+	return m.run(ctx, func(applied map[int]time.Time) ([]Step, error) {
+		var steps []Step
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; !ok {
+				steps = append(steps, Step{Migration: migration})
+			}
+		}
+		return steps, nil
+	})
+}
+
+// revert the newest applied migration
+func (m *Migrator) Down(ctx context.Context) ([]Step, error) {
+	return m.run(ctx, func(applied map[int]time.Time) ([]Step, error) {
+		latest := 0
+		for version := range applied {
+			if version > latest {
+				latest = version
+			}
+		}
+		if latest == 0 {
+			return nil, nil
+		}
+		return m.revert([]int{latest})
+	})
+}
+
+// migrate up or down so everything up to version is applied and nothing
+// after it is, 0 reverts every migration
+func (m *Migrator) To(ctx context.Context, version int) ([]Step, error) {
+	if version < 0 {
+		return nil, fmt.Errorf("invalid version %d", version)
+	}
+	if version > 0 && m.find(version) == nil {
+		return nil, fmt.Errorf("there is no migration %d", version)
+	}
+
+	return m.run(ctx, func(applied map[int]time.Time) ([]Step, error) {
+		var newer []int
+		for v := range applied {
+			if v > version {
+				newer = append(newer, v)
+			}
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(newer)))
+
+		steps, err := m.revert(newer)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; !ok && migration.Version <= version {
+				steps = append(steps, Step{Migration: migration})
+			}
+		}
+		return steps, nil
+	})
+}
+
+// record the migrations up to version as applied without running them, for a
+// database whose schema was created some other way. Only a database with
+// nothing recorded yet can be given a baseline
+func (m *Migrator) Baseline(ctx context.Context, version int) ([]Step, error) {
+	if m.find(version) == nil {
+		return nil, fmt.Errorf("there is no migration %d", version)
+	}
+
+	return m.run(ctx, func(applied map[int]time.Time) ([]Step, error) {
+		if len(applied) > 0 {
+			return nil, fmt.Errorf("the database already has migrations applied")
+		}
+
+		var steps []Step
+		for _, migration := range m.migrations {
+			if migration.Version <= version {
+				steps = append(steps, Step{Migration: migration, RecordOnly: true})
+			}
+		}
+		return steps, nil
+	})
+}
+
+// every known migration and every applied one, oldest first
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	applied := make(map[int]time.Time)
+	names := make(map[int]string)
+
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, `select to_regclass('public.schema_migrations') is not null`).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+
+	if exists {
+		rows, err := m.DB.QueryContext(ctx, `select version, name, applied_at from schema_migrations`)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var version int
+			var name string
+			var appliedAt time.Time
+			err = rows.Scan(&version, &name, &appliedAt)
+			if err != nil {
+				return nil, err
+			}
+			applied[version] = appliedAt
+			names[version] = name
+		}
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	var statuses []Status
+	for _, migration := range m.migrations {
+		appliedAt, ok := applied[migration.Version]
+		statuses = append(statuses, Status{Migration: migration, Applied: ok, AppliedAt: appliedAt})
+		delete(applied, migration.Version)
+	}
+	for version, appliedAt := range applied {
+		statuses = append(statuses, Status{
+			Migration: Migration{Version: version, Name: names[version]},
+			Applied:   true,
+			AppliedAt: appliedAt,
+			Unknown:   true,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+
+	return statuses, nil
+}
+
+// take the lock, work out what to do from the migrations already applied and
+// do it. Each migration runs in its own transaction along with recording it,
+// so a failed migration leaves nothing behind and the ones before it stay applied
+func (m *Migrator) run(ctx context.Context, plan func(applied map[int]time.Time) ([]Step, error)) ([]Step, error) {
+	// the lock belongs to a session, so everything happens on one connection
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `select pg_advisory_lock($1)`, int64(lockKey))
+	if err != nil {
+		return nil, fmt.Errorf("waiting for the migration lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), `select pg_advisory_unlock($1)`, int64(lockKey))
+
+	_, err = conn.ExecContext(ctx, `
+		create table if not exists public.schema_migrations (
+			version bigint primary key,
+			name character varying(255) not null,
+			applied_at timestamp without time zone not null
+		)`)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedVersions(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	steps, err := plan(applied)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Step
+	for _, step := range steps {
+		err = runStep(ctx, conn, step)
+		if err != nil {
+			return done, err
+		}
+		done = append(done, step)
+	}
+
+	return done, nil
+}
+
+func runStep(ctx context.Context, conn *sql.Conn, step Step) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	switch {
+	case step.Revert:
+		_, err = tx.ExecContext(ctx, step.Down)
+		if err == nil {
+			_, err = tx.ExecContext(ctx, `delete from schema_migrations where version = $1`, step.Version)
+		}
+	case step.RecordOnly:
+		err = record(ctx, tx, step.Migration)
+	default:
+		_, err = tx.ExecContext(ctx, step.Up)
+		if err == nil {
+			err = record(ctx, tx, step.Migration)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("migration %s: %w", step.Migration, err)
+	}
+
+	return tx.Commit()
+}
+
+func record(ctx context.Context, tx *sql.Tx, migration Migration) error {
+	_, err := tx.ExecContext(ctx, `insert into schema_migrations (version, name, applied_at) values ($1, $2, $3)`,
+		migration.Version, migration.Name, time.Now())
+	return err
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `select version, applied_at from schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		err = rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+// the down steps for the applied versions given, in the order given
+func (m *Migrator) revert(versions []int) ([]Step, error) {
+	var steps []Step
+	for _, version := range versions {
+		migration := m.find(version)
+		if migration == nil {
+			return nil, fmt.Errorf("migration %d was applied by a newer build and can't be reverted by this one", version)
+		}
+		if migration.Down == "" {
+			return nil, fmt.Errorf("migration %s can't be reverted", migration)
+		}
+		steps = append(steps, Step{Migration: *migration, Revert: true})
+	}
+	return steps, nil
+}
+
+func (m *Migrator) find(version int) *Migration {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return &m.migrations[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/toluhikay/go-react/migrations"
+)
+
+func file(body string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(body)}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name     string
+		fsys     fstest.MapFS
+		versions []int
+		ok       bool
+	}{
+		{"empty", fstest.MapFS{}, nil, true},
+		{
+			"sorted by version not name",
+			fstest.MapFS{
+				"0010_ten.up.sql":  file("select 10"),
+				"0002_two.up.sql":  file("select 2"),
+				"0001_one.up.sql":  file("select 1"),
+				"0009_nine.up.sql": file("select 9"),
+			},
+			[]int{1, 2, 9, 10},
+			true,
+		},
+		{
+			"other files ignored",
+			fstest.MapFS{
+				"0001_one.up.sql":    file("select 1"),
+				"README.md":          file("notes"),
+				"0002_two.sql":       file("select 2"),
+				"0003_three.up.txt":  file("select 3"),
+				"0004/0004_x.up.sql": file("select 4"),
+			},
+			[]int{1},
+			true,
+		},
+		{
+			"same version, different names",
+			fstest.MapFS{
+				"0001_one.up.sql":   file("select 1"),
+				"0001_other.up.sql": file("select 1"),
+			},
+			nil,
+			false,
+		},
+		{"down without an up", fstest.MapFS{"0001_one.down.sql": file("select 1")}, nil, false},
+		{"empty up", fstest.MapFS{"0001_one.up.sql": file("")}, nil, false},
+		{"version zero", fstest.MapFS{"0000_zero.up.sql": file("select 0")}, nil, false},
+		{"version too large", fstest.MapFS{"99999999999999999999_big.up.sql": file("select 1")}, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := New(nil, tt.fsys)
+			if !tt.ok {
+				if err == nil {
+					t.Fatal("New accepted the migrations")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var versions []int
+			for _, migration := range m.migrations {
+				versions = append(versions, migration.Version)
+			}
+			if len(versions) != len(tt.versions) {
+				t.Fatalf("versions %v, want %v", versions, tt.versions)
+			}
+			for i := range versions {
+				if versions[i] != tt.versions[i] {
+					t.Fatalf("versions %v, want %v", versions, tt.versions)
+				}
+			}
+
+			latest := 0
+			if len(tt.versions) > 0 {
+				latest = tt.versions[len(tt.versions)-1]
+			}
+			if m.Latest() != latest {
+				t.Errorf("Latest() = %d, want %d", m.Latest(), latest)
+			}
+		})
+	}
+}
+
+func TestNewPairsUpAndDown(t *testing.T) {
+	m, err := New(nil, fstest.MapFS{
+		"0001_one.up.sql":   file("create table one ()"),
+		"0001_one.down.sql": file("drop table one"),
+		"0002_two.up.sql":   file("create table two ()"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	one, two := m.find(1), m.find(2)
+	if one.Up != "create table one ()" || one.Down != "drop table one" {
+		t.Errorf("0001 read as %+v", *one)
+	}
+	if one.String() != "0001_one" {
+		t.Errorf("String() = %q, want 0001_one", one.String())
+	}
+
+	// reverting goes in the order asked and stops at the one without a down
+	steps, err := m.revert([]int{1})
+	if err != nil || len(steps) != 1 || !steps[0].Revert {
+		t.Errorf("revert(1) = %+v, %v", steps, err)
+	}
+	if two.Down != "" {
+		t.Errorf("0002 has a down migration %q", two.Down)
+	}
+	if _, err := m.revert([]int{2, 1}); err == nil {
+		t.Error("reverted a migration without a down")
+	}
+	if _, err := m.revert([]int{3}); err == nil {
+		t.Error("reverted a migration this build doesn't know")
+	}
+}
+
+// the shipped migrations read cleanly and each one can be reverted
+func TestShippedMigrations(t *testing.T) {
+	m, err := New(nil, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, migration := range m.migrations {
+		if migration.Version != i+1 {
+			t.Errorf("migration %s is out of sequence, want version %d", migration, i+1)
+		}
+		if migration.Down == "" {
+			t.Errorf("migration %s has no down migration", migration)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// one append-only record of who did what to which entity
+type AuditEntry struct {
+	ID int64 `json:"id"`
+	// 0 when nobody was logged in, e.g. a failed login
+	ActorID int `json:"actor_id,omitempty"`
+	// the admin behind the actor when the request was made while impersonating
+	ImpersonatorID int             `json:"impersonator_id,omitempty"`
+	Action         string          `json:"action"`
+	Entity         string          `json:"entity,omitempty"`
+	EntityID       string          `json:"entity_id,omitempty"`
+	Before         json.RawMessage `json:"before,omitempty"`
+	After          json.RawMessage `json:"after,omitempty"`
+	Diff           json.RawMessage `json:"diff,omitempty"`
+	IPAddress      string          `json:"ip_address"`
+	RequestID      string          `json:"request_id,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// narrows down the audit entries returned, zero values match everything
+type AuditFilter struct {
+	ActorID  int
+	Entity   string
+	EntityID string
+	Action   string
+	From     time.Time
+	To       time.Time
+	// only entries older than this id, for paging backwards through the log
+	Before int64
+	Limit  int
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// failed login attempts tracked for a key such as an account or a client ip
+type LoginAttempts struct {
+	Key           string    `json:"key"`
+	Failures      int       `json:"failures"`
+	LastFailureAt time.Time `json:"last_failure_at"`
+	LockedUntil   time.Time `json:"locked_until"`
+}
@@ -12,6 +12,8 @@ type Movie struct {
 	Image       string    `json:"image"`
 	CreatedAt   time.Time `json:"-"`
 	UpdatedAt   time.Time `json:"-"`
+	CreatedBy   int       `json:"created_by,omitempty"`
+	UpdatedBy   int       `json:"updated_by,omitempty"`
 	Genres      []*Genre  `json:"genres,omitempty"`
 	GenresArray []int     `json:"genres_array,omitempty"`
 }
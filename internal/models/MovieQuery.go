@@ -0,0 +1,33 @@
+package models
+
+// columns movies can be sorted by
+const (
+	MovieSortTitle       = "title"
+	MovieSortReleaseDate = "release_date"
+	MovieSortRuntime     = "runtime"
+	MovieSortCreatedAt   = "created_at"
+)
+
+// a page of the movie catalogue. Paging is keyset based, After holds the sort
+// value and id of the last movie on the previous page
+type MovieQuery struct {
+	Sort  string
+	Desc  bool
+	Limit int
+	After *MovieCursor
+
+	Ratings    []string
+	YearFrom   int
+	YearTo     int
+	RuntimeMin int
+	RuntimeMax int
+	// movies in any of these genres
+	Genres []int
+}
+
+// where a page starts, the sort column value is kept as text so it can be
+// carried in a url
+type MovieCursor struct {
+	Value string
+	ID    int
+}
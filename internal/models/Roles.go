@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// roles seeded in the database, ordered from least to most privileged
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+// permissions that can be granted to a role and checked per route
+const (
+	PermMoviesRead   = "movies:read"
+	PermMoviesWrite  = "movies:write"
+	PermMoviesDelete = "movies:delete"
+	// edit movies created by someone else, movies:write only covers your own
+	PermMoviesWriteAny   = "movies:write_any"
+	PermUsersManage      = "users:manage"
+	PermUsersImpersonate = "users:impersonate"
+	PermAuditRead        = "audit:read"
+)
+
+type Role struct {
+	ID          int       `json:"id"`
+	Role        string    `json:"role"`
+	MFARequired bool      `json:"mfa_required"`
+	Permissions []string  `json:"permissions,omitempty"`
+	CreatedAt   time.Time `json:"-"`
+	UpdatedAt   time.Time `json:"-"`
+}
@@ -0,0 +1,20 @@
+package models
+
+// a full text search of the catalogue, optionally narrowed by rating and genre
+type MovieSearch struct {
+	Query   string
+	Ratings []string
+	// movies in any of these genres
+	Genres []int
+	Limit  int
+	Offset int
+}
+
+// a movie matching a search, with the matches in the title and description
+// wrapped in <mark> tags
+type MovieSearchResult struct {
+	Movie
+	Rank           float64 `json:"rank"`
+	TitleHighlight string  `json:"title_highlight"`
+	Snippet        string  `json:"snippet"`
+}
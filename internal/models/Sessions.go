@@ -0,0 +1,30 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// a logged in device, every refresh token rotated from the same login belongs to one session
+type Session struct {
+	ID         string       `json:"id"`
+	UserID     int          `json:"-"`
+	UserAgent  string       `json:"user_agent"`
+	IPAddress  string       `json:"ip_address"`
+	CreatedAt  time.Time    `json:"created_at"`
+	LastUsedAt time.Time    `json:"last_used_at"`
+	RevokedAt  sql.NullTime `json:"-"`
+	Current    bool         `json:"current"`
+}
+
+// a refresh token issued to a session, identified by the jti claim
+type RefreshToken struct {
+	ID         string
+	SessionID  string
+	UserID     int
+	ExpiresAt  time.Time
+	CreatedAt  time.Time
+	UsedAt     sql.NullTime
+	ReplacedBy string
+	RevokedAt  sql.NullTime
+}
@@ -8,13 +8,19 @@ import (
 )
 
 type User struct {
-	ID        int       `json:"id"`
-	FirstName string    `json:"first_name"`
-	LastName  string    `json:"last_name"`
-	Email     string    `json:"email"`
-	Password  string    `json:"password"`
-	CreatedAt time.Time `json:"-"`
-	UpdatedAt time.Time `json:"-"`
+	ID                    int       `json:"id"`
+	FirstName             string    `json:"first_name"`
+	LastName              string    `json:"last_name"`
+	Email                 string    `json:"email"`
+	Password              string    `json:"-"`
+	EmailVerified         bool      `json:"email_verified"`
+	TOTPSecret            string    `json:"-"`
+	TOTPEnabled           bool      `json:"mfa_enabled"`
+	PasswordResetRequired bool      `json:"password_reset_required"`
+	Disabled              bool      `json:"disabled"`
+	Roles                 []string  `json:"roles,omitempty"`
+	CreatedAt             time.Time `json:"-"`
+	UpdatedAt             time.Time `json:"-"`
 }
 
 // create a function toverify password
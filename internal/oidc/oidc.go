@@ -0,0 +1,346 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// how often we allow the provider's keys to be fetched again for an unknown kid
+const keyRefreshInterval = time.Minute
+
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// an openid connect provider, discovered from its issuer on first use
+type Provider struct {
+	Config Config
+	Client *http.Client
+
+	mu          sync.RWMutex
+	discovered  bool
+	authURL     string
+	tokenURL    string
+	jwksURL     string
+	keys        map[string]interface{}
+	keysFetched time.Time
+}
+
+func NewProvider(cfg Config) *Provider {
+	return &Provider{
+		Config: cfg,
+		Client: &http.Client{Timeout: time.Second * 10},
+	}
+}
+
+// the claims we care about in an id token
+type IDTokenClaims struct {
+	Email         string   `json:"email"`
+	EmailVerified flexBool `json:"email_verified"`
+	GivenName     string   `json:"given_name"`
+	FamilyName    string   `json:"family_name"`
+	Name          string   `json:"name"`
+	Nonce         string   `json:"nonce"`
+	AMR           []string `json:"amr"`
+	jwt.RegisteredClaims
+}
+
+// some providers send email_verified as a string
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	switch strings.Trim(string(data), `"`) {
+	case "true":
+		*b = true
+	default:
+		*b = false
+	}
+	return nil
+}
+
+// create a pkce code verifier and its S256 challenge
+func NewPKCE() (string, string, error) {
+	verifier, err := RandomString()
+	if err != nil {
+		return "", "", err
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// a random url safe string for state, nonce and pkce values
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// read the provider's endpoints from its discovery document
+func (p *Provider) discover(ctx context.Context) error {
+	p.mu.RLock()
+	done := p.discovered
+	p.mu.RUnlock()
+	if done {
+		return nil
+	}
+
+	var doc struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+
+	wellKnown := strings.TrimSuffix(p.Config.Issuer, "/") + "/.well-known/openid-configuration"
+	err := p.getJSON(ctx, wellKnown, &doc)
+	if err != nil {
+		return fmt.Errorf("oidc discovery: %w", err)
+	}
+
+	if doc.Issuer != p.Config.Issuer {
+		return fmt.Errorf("oidc discovery: issuer %q does not match %q", doc.Issuer, p.Config.Issuer)
+	}
+
+	p.mu.Lock()
+	p.authURL = doc.AuthorizationEndpoint
+	p.tokenURL = doc.TokenEndpoint
+	p.jwksURL = doc.JWKSURI
+	p.discovered = true
+	p.mu.Unlock()
+
+	return nil
+}
+
+// build the url to send the user to, to log in with the provider
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.Config.ClientID)
+	v.Set("redirect_uri", p.Config.RedirectURL)
+	v.Set("scope", strings.Join(p.Config.Scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", codeChallenge)
+	v.Set("code_challenge_method", "S256")
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	sep := "?"
+	if strings.Contains(p.authURL, "?") {
+		sep = "&"
+	}
+	return p.authURL + sep + v.Encode(), nil
+}
+
+// swap the authorization code for tokens and return the raw id token
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier string) (string, error) {
+	err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.Config.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+	form.Set("client_id", p.Config.ClientID)
+
+	p.mu.RLock()
+	tokenURL := p.tokenURL
+	p.mu.RUnlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.Config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.Config.ClientID), url.QueryEscape(p.Config.ClientSecret))
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("oidc token endpoint returned %d: %s", resp.StatusCode, body)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	err = json.Unmarshal(body, &tokens)
+	if err != nil {
+		return "", err
+	}
+	if tokens.IDToken == "" {
+		return "", errors.New("oidc token response has no id_token")
+	}
+
+	return tokens.IDToken, nil
+}
+
+// check the id token's signature, issuer, audience, expiry and nonce
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (*IDTokenClaims, error) {
+	err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &IDTokenClaims{}
+	_, err = jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA, *jwt.SigningMethodEd25519:
+		default:
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Issuer != p.Config.Issuer {
+		return nil, errors.New("id token has the wrong issuer")
+	}
+	if !claims.VerifyAudience(p.Config.ClientID, true) {
+		return nil, errors.New("id token was not issued for this client")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("id token has no subject")
+	}
+	if nonce == "" || claims.Nonce != nonce {
+		return nil, errors.New("id token nonce does not match")
+	}
+
+	return claims, nil
+}
+
+// find a signing key by kid, refetching the provider's keys if we don't know it yet
+func (p *Provider) key(ctx context.Context, kid string) (interface{}, error) {
+	p.mu.RLock()
+	key, ok := p.keys[kid]
+	fetched := p.keysFetched
+	p.mu.RUnlock()
+
+	if ok {
+		return key, nil
+	}
+
+	if time.Since(fetched) < keyRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	err := p.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	// a provider with a single key may not bother setting kid
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, nil
+		}
+	}
+
+	key, ok = p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+func (p *Provider) fetchKeys(ctx context.Context) error {
+	p.mu.RLock()
+	jwksURL := p.jwksURL
+	p.mu.RUnlock()
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+
+	err := p.getJSON(ctx, jwksURL, &set)
+	if err != nil {
+		return fmt.Errorf("oidc jwks: %w", err)
+	}
+
+	keys := make(map[string]interface{})
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.keysFetched = time.Now()
+	p.mu.Unlock()
+
+	return nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, data interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", url, resp.StatusCode)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(data)
+}
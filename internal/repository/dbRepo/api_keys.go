@@ -0,0 +1,139 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/toluhikay/go-react/internal/models"
+)
+
+// scopes are kept space separated, like oauth scopes
+func joinScopes(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
+
+func splitScopes(scopes string) []string {
+	fields := strings.Fields(scopes)
+	if fields == nil {
+		return []string{}
+	}
+	return fields
+}
+
+func (m *PostgresDbRepo) InsertAPIKey(key models.APIKey, keyHash string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into api_keys (user_id, name, prefix, key_hash, scopes, created_at)
+			values ($1, $2, $3, $4, $5, $6) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		key.UserID,
+		key.Name,
+		key.Prefix,
+		keyHash,
+		joinScopes(key.Scopes),
+		key.CreatedAt,
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// list a user's keys that haven't been revoked
+func (m *PostgresDbRepo) GetUserAPIKeys(userID int) ([]*models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	query := `select id, user_id, name, prefix, scopes, created_at, last_used_at
+			from api_keys where user_id = $1 and revoked_at is null
+			order by created_at desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// find a live key by its hash and stamp it as used
+func (m *PostgresDbRepo) UseAPIKey(keyHash string) (*models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	stmt := `update api_keys set last_used_at = $1
+			where key_hash = $2 and revoked_at is null
+			returning id, user_id, name, prefix, scopes, created_at, last_used_at`
+
+	return scanAPIKey(m.DB.QueryRowContext(ctx, stmt, time.Now(), keyHash))
+}
+
+// revoke one of a user's keys, returning sql.ErrNoRows if they don't own it
+func (m *PostgresDbRepo) RevokeAPIKey(userID, id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	stmt := `update api_keys set revoked_at = $1 where id = $2 and user_id = $3 and revoked_at is null`
+
+	result, err := m.DB.ExecContext(ctx, stmt, time.Now(), id, userID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIKey(row scanner) (*models.APIKey, error) {
+	var key models.APIKey
+	var scopes string
+	var lastUsed sql.NullTime
+
+	err := row.Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		&scopes,
+		&key.CreatedAt,
+		&lastUsed,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Scopes = splitScopes(scopes)
+	if lastUsed.Valid {
+		key.LastUsedAt = &lastUsed.Time
+	}
+
+	return &key, nil
+}
@@ -0,0 +1,139 @@
+package dbrepo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/toluhikay/go-react/internal/models"
+)
+
+func (m *PostgresDbRepo) InsertAuditEntry(entry models.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	stmt := `insert into audit_log (actor_id, impersonator_id, action, entity, entity_id,
+			before, after, diff, ip_address, request_id, created_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		nullID(entry.ActorID),
+		nullID(entry.ImpersonatorID),
+		entry.Action,
+		entry.Entity,
+		entry.EntityID,
+		nullJSON(entry.Before),
+		nullJSON(entry.After),
+		nullJSON(entry.Diff),
+		entry.IPAddress,
+		entry.RequestID,
+		entry.CreatedAt,
+	)
+	return err
+}
+
+// return the newest entries matching the filter first
+func (m *PostgresDbRepo) GetAuditEntries(filter models.AuditFilter) ([]*models.AuditEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// build the where clause from whichever filters were set
+	var where []string
+	var args []interface{}
+	add := func(clause string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(clause, len(args)))
+	}
+
+	if filter.ActorID != 0 {
+		add("(actor_id = $%[1]d or impersonator_id = $%[1]d)", filter.ActorID)
+	}
+	if filter.Entity != "" {
+		add("entity = $%d", filter.Entity)
+	}
+	if filter.EntityID != "" {
+		add("entity_id = $%d", filter.EntityID)
+	}
+	if filter.Action != "" {
+		add("action = $%d", filter.Action)
+	}
+	if !filter.From.IsZero() {
+		add("created_at >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		add("created_at < $%d", filter.To)
+	}
+	if filter.Before != 0 {
+		add("id < $%d", filter.Before)
+	}
+
+	clause := ""
+	if len(where) > 0 {
+		clause = "where " + strings.Join(where, " and ")
+	}
+
+	args = append(args, filter.Limit)
+	query := fmt.Sprintf(`
+		select
+			id, coalesce(actor_id, 0), coalesce(impersonator_id, 0), action, entity, entity_id,
+			coalesce(before, 'null'), coalesce(after, 'null'), coalesce(diff, 'null'),
+			ip_address, request_id, created_at
+		from
+			audit_log %s
+		order by
+			id desc
+		limit $%d
+	`, clause, len(args))
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*models.AuditEntry{}
+	for rows.Next() {
+		var entry models.AuditEntry
+		var before, after, diff []byte
+		err := rows.Scan(
+			&entry.ID,
+			&entry.ActorID,
+			&entry.ImpersonatorID,
+			&entry.Action,
+			&entry.Entity,
+			&entry.EntityID,
+			&before,
+			&after,
+			&diff,
+			&entry.IPAddress,
+			&entry.RequestID,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		entry.Before = rawJSON(before)
+		entry.After = rawJSON(after)
+		entry.Diff = rawJSON(diff)
+		entries = append(entries, &entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// store empty json as null rather than an invalid jsonb value
+func nullJSON(data []byte) interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+	return string(data)
+}
+
+// drop json nulls so they are left out of the response
+func rawJSON(data []byte) []byte {
+	if string(data) == "null" {
+		return nil
+	}
+	return data
+}
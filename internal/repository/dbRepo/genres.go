@@ -0,0 +1,112 @@
+package dbrepo
+
+import (
+	"context"
+
+	"github.com/toluhikay/go-react/internal/models"
+)
+
+// the genres of each of the movies in one query, keyed by movie id. Every id
+// asked for gets an entry, empty for a movie without genres
+func (m *PostgresDbRepo) GenresByMovieIDs(ctx context.Context, ids []int) (map[int][]*models.Genre, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeOut)
+	defer cancel()
+
+	query := `
+		select mg.movie_id, g.id, g.genre, g.created_at, g.updated_at
+		from movies_genres mg
+		join genres g on g.id = mg.genre_id
+		where mg.movie_id = any($1)
+		order by g.genre
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := make(map[int][]*models.Genre, len(ids))
+	for _, id := range ids {
+		genres[id] = []*models.Genre{}
+	}
+
+	for rows.Next() {
+		var movieID int
+		var g models.Genre
+		err := rows.Scan(
+			&movieID,
+			&g.ID,
+			&g.Genre,
+			&g.CreatedAt,
+			&g.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		genres[movieID] = append(genres[movieID], &g)
+	}
+
+	return genres, rows.Err()
+}
+
+// the movies in each of the genres in one query, keyed by genre id, at most
+// limit of them for each genre. Every id asked for gets an entry, empty for a
+// genre without movies
+func (m *PostgresDbRepo) MoviesByGenreIDs(ctx context.Context, ids []int, limit int) (map[int][]*models.Movie, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeOut)
+	defer cancel()
+
+	query := `
+		select
+			genre_id, id, title, release_date, runtime,
+			mpaa_rating, description, image,
+			created_at, updated_at
+		from (
+			select
+				mg.genre_id, m.id, m.title, m.release_date, m.runtime,
+				m.mpaa_rating, m.description, coalesce(m.image, '') as image,
+				m.created_at, m.updated_at,
+				row_number() over (partition by mg.genre_id order by m.title, m.id) as n
+			from movies_genres mg
+			join movies m on m.id = mg.movie_id
+			where mg.genre_id = any($1)
+		) ranked
+		where n <= $2
+		order by title, id
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, ids, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := make(map[int][]*models.Movie, len(ids))
+	for _, id := range ids {
+		movies[id] = []*models.Movie{}
+	}
+
+	for rows.Next() {
+		var genreID int
+		var movie models.Movie
+		err := rows.Scan(
+			&genreID,
+			&movie.ID,
+			&movie.Title,
+			&movie.ReleaseDate,
+			&movie.RunTime,
+			&movie.MPAARating,
+			&movie.Description,
+			&movie.Image,
+			&movie.CreatedAt,
+			&movie.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		movies[genreID] = append(movies[genreID], &movie)
+	}
+
+	return movies, rows.Err()
+}
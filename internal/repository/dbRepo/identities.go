@@ -0,0 +1,35 @@
+package dbrepo
+
+import (
+	"context"
+	"time"
+
+	"github.com/toluhikay/go-react/internal/models"
+)
+
+// find the local user linked to an identity at an external provider
+func (m *PostgresDbRepo) GetUserByIdentity(issuer, subject string) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	var userID int
+	query := `select user_id from user_identities where issuer = $1 and subject = $2`
+
+	err := m.DB.QueryRowContext(ctx, query, issuer, subject).Scan(&userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.GetUSerById(userID)
+}
+
+// link a local user to an identity at an external provider
+func (m *PostgresDbRepo) LinkIdentity(userID int, issuer, subject string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	stmt := `insert into user_identities (user_id, issuer, subject, created_at) values ($1, $2, $3, $4)`
+
+	_, err := m.DB.ExecContext(ctx, stmt, userID, issuer, subject, time.Now())
+	return err
+}
@@ -0,0 +1,78 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/toluhikay/go-react/internal/models"
+)
+
+func (m *PostgresDbRepo) GetLoginAttempts(key string) (*models.LoginAttempts, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	query := `select key, failures, last_failure_at, coalesce(locked_until, 'epoch')
+			from login_attempts where key = $1`
+
+	var attempts models.LoginAttempts
+	err := m.DB.QueryRowContext(ctx, query, key).Scan(
+		&attempts.Key,
+		&attempts.Failures,
+		&attempts.LastFailureAt,
+		&attempts.LockedUntil,
+	)
+	if err != nil {
+		// no row just means no failures yet
+		if errors.Is(err, sql.ErrNoRows) {
+			return &models.LoginAttempts{Key: key}, nil
+		}
+		return nil, err
+	}
+
+	return &attempts, nil
+}
+
+func (m *PostgresDbRepo) AddLoginFailure(key string, now time.Time, window time.Duration) (*models.LoginAttempts, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// one upsert so concurrent failures can't lose a count
+	stmt := `insert into login_attempts (key, failures, last_failure_at) values ($1, 1, $2)
+			on conflict (key) do update set
+				failures = case when login_attempts.last_failure_at < $3 then 1 else login_attempts.failures + 1 end,
+				last_failure_at = $2
+			returning key, failures, last_failure_at, coalesce(locked_until, 'epoch')`
+
+	var attempts models.LoginAttempts
+	err := m.DB.QueryRowContext(ctx, stmt, key, now, now.Add(-window)).Scan(
+		&attempts.Key,
+		&attempts.Failures,
+		&attempts.LastFailureAt,
+		&attempts.LockedUntil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &attempts, nil
+}
+
+func (m *PostgresDbRepo) LockLogin(key string, until time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	stmt := `update login_attempts set locked_until = $1 where key = $2`
+
+	_, err := m.DB.ExecContext(ctx, stmt, until, key)
+	return err
+}
+
+func (m *PostgresDbRepo) ResetLoginAttempts(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from login_attempts where key = $1`, key)
+	return err
+}
@@ -0,0 +1,111 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// store a new totp secret waiting to be confirmed, replacing any earlier unconfirmed one
+func (m *PostgresDbRepo) SetUserTOTPSecret(userID int, secret string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	stmt := `update users set totp_secret = $1, totp_enabled = false, totp_last_step = 0, updated_at = $2
+			where id = $3 and totp_enabled = false`
+
+	result, err := m.DB.ExecContext(ctx, stmt, secret, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// turn on totp for a user and replace their recovery codes
+func (m *PostgresDbRepo) EnableUserTOTP(userID int, step int64, recoveryCodeHashes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `update users set totp_enabled = true, totp_last_step = $1, updated_at = $2 where id = $3`
+	_, err = tx.ExecContext(ctx, stmt, step, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from mfa_recovery_codes where user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range recoveryCodeHashes {
+		stmt := `insert into mfa_recovery_codes (user_id, code_hash, created_at) values ($1, $2, $3)`
+		_, err := tx.ExecContext(ctx, stmt, userID, hash, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// record the time step of an accepted code. Returns sql.ErrNoRows when that
+// step or a later one was already used, so a code can't be replayed
+func (m *PostgresDbRepo) UseTOTPStep(userID int, step int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	stmt := `update users set totp_last_step = $1 where id = $2 and totp_last_step < $1`
+
+	result, err := m.DB.ExecContext(ctx, stmt, step, userID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// use up one recovery code, returning sql.ErrNoRows if it's unknown or already used
+func (m *PostgresDbRepo) UseRecoveryCode(userID int, codeHash string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	stmt := `update mfa_recovery_codes set used_at = $1 where user_id = $2 and code_hash = $3 and used_at is null`
+
+	result, err := m.DB.ExecContext(ctx, stmt, time.Now(), userID, codeHash)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
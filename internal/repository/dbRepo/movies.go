@@ -0,0 +1,113 @@
+package dbrepo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/toluhikay/go-react/internal/models"
+)
+
+// the expression each sort column orders by and the type its cursor value is
+// cast to. Nulls are folded into a value so keyset comparisons stay total
+var movieSortColumns = map[string]struct {
+	expr string
+	cast string
+}{
+	models.MovieSortTitle:       {"coalesce(title, '')", "varchar"},
+	models.MovieSortReleaseDate: {"coalesce(release_date, '0001-01-01')", "date"},
+	models.MovieSortRuntime:     {"coalesce(runtime, 0)", "integer"},
+	models.MovieSortCreatedAt:   {"coalesce(created_at, '0001-01-01')", "timestamp"},
+}
+
+// list a page of movies matching the query
+func (m *PostgresDbRepo) ListMovies(ctx context.Context, q models.MovieQuery) ([]*models.Movie, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeOut)
+	defer cancel()
+
+	sort, ok := movieSortColumns[q.Sort]
+	if !ok {
+		return nil, fmt.Errorf("can't sort movies by %q", q.Sort)
+	}
+
+	var where []string
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if len(q.Ratings) > 0 {
+		where = append(where, "mpaa_rating = any("+arg(q.Ratings)+")")
+	}
+	if q.YearFrom != 0 {
+		where = append(where, "release_date >= make_date("+arg(q.YearFrom)+", 1, 1)")
+	}
+	if q.YearTo != 0 {
+		where = append(where, "release_date < make_date("+arg(q.YearTo+1)+", 1, 1)")
+	}
+	if q.RuntimeMin != 0 {
+		where = append(where, "runtime >= "+arg(q.RuntimeMin))
+	}
+	if q.RuntimeMax != 0 {
+		where = append(where, "runtime <= "+arg(q.RuntimeMax))
+	}
+	if len(q.Genres) > 0 {
+		where = append(where, "id in (select movie_id from movies_genres where genre_id = any("+arg(q.Genres)+"))")
+	}
+
+	direction, compare := "asc", ">"
+	if q.Desc {
+		direction, compare = "desc", "<"
+	}
+
+	if q.After != nil {
+		where = append(where, fmt.Sprintf("(%s, id) %s (%s::%s, %s)",
+			sort.expr, compare, arg(q.After.Value), sort.cast, arg(q.After.ID)))
+	}
+
+	clause := ""
+	if len(where) > 0 {
+		clause = "where " + strings.Join(where, " and ")
+	}
+
+	query := fmt.Sprintf(`
+		select
+			id, title, release_date, runtime,
+			mpaa_rating, description, coalesce(image, ''),
+			created_at, updated_at
+		from
+			movies %s
+		order by
+			%s %s, id %s
+		limit %s
+	`, clause, sort.expr, direction, direction, arg(q.Limit))
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := []*models.Movie{}
+	for rows.Next() {
+		var movie models.Movie
+		err := rows.Scan(
+			&movie.ID,
+			&movie.Title,
+			&movie.ReleaseDate,
+			&movie.RunTime,
+			&movie.MPAARating,
+			&movie.Description,
+			&movie.Image,
+			&movie.CreatedAt,
+			&movie.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		movies = append(movies, &movie)
+	}
+
+	return movies, rows.Err()
+}
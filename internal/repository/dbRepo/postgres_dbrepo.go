@@ -84,7 +84,8 @@ func (m *PostgresDbRepo) GetOneMovie(id int) (*models.Movie, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
 	defer cancel()
 
-	query := `select id, title, release_date, runtime, mpaa_rating, description, coalesce(image, ''), created_at, updated_at
+	query := `select id, title, release_date, runtime, mpaa_rating, description, coalesce(image, ''), created_at, updated_at,
+		coalesce(created_by, 0), coalesce(updated_by, 0)
 		from movies where id = $1
 	`
 	var movie models.Movie
@@ -100,6 +101,8 @@ func (m *PostgresDbRepo) GetOneMovie(id int) (*models.Movie, error) {
 		&movie.Image,
 		&movie.CreatedAt,
 		&movie.UpdatedAt,
+		&movie.CreatedBy,
+		&movie.UpdatedBy,
 	)
 	if err != nil {
 		return nil, err
@@ -144,7 +147,8 @@ func (m *PostgresDbRepo) GetOneMovieForEdit(id int) (*models.Movie, []*models.Ge
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
 	defer cancel()
 
-	query := `select id, title, release_date, runtime, mpaa_rating, description, coalesce(image, ''), created_at, updated_at
+	query := `select id, title, release_date, runtime, mpaa_rating, description, coalesce(image, ''), created_at, updated_at,
+		coalesce(created_by, 0), coalesce(updated_by, 0)
 		from movies where id = $1
 	`
 	var movie models.Movie
@@ -160,6 +164,8 @@ func (m *PostgresDbRepo) GetOneMovieForEdit(id int) (*models.Movie, []*models.Ge
 		&movie.Image,
 		&movie.CreatedAt,
 		&movie.UpdatedAt,
+		&movie.CreatedBy,
+		&movie.UpdatedBy,
 	)
 	if err != nil {
 		return nil, nil, err
@@ -230,7 +236,8 @@ func (m *PostgresDbRepo) GetUserByEMail(email string) (*models.User, error) {
 	defer cancel()
 
 	// create the query
-	query := `select id, email, first_name, last_name, password, created_at, updated_at
+	query := `select id, email, first_name, last_name, password, email_verified, coalesce(totp_secret, ''), totp_enabled,
+			password_reset_required, disabled_at is not null, created_at, updated_at
 			from users where email = $1
 	`
 	// scan the user into a row
@@ -239,10 +246,15 @@ func (m *PostgresDbRepo) GetUserByEMail(email string) (*models.User, error) {
 
 	err := row.Scan(
 		&user.ID,
-		&user.FirstName,
 		&user.Email,
-		&user.Password,
+		&user.FirstName,
 		&user.LastName,
+		&user.Password,
+		&user.EmailVerified,
+		&user.TOTPSecret,
+		&user.TOTPEnabled,
+		&user.PasswordResetRequired,
+		&user.Disabled,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -259,7 +271,8 @@ func (m *PostgresDbRepo) GetUSerById(id int) (*models.User, error) {
 	defer cancel()
 
 	// query db with user id
-	query := `select id, email, first_name, last_name, password, created_at, updated_at
+	query := `select id, email, first_name, last_name, password, email_verified, coalesce(totp_secret, ''), totp_enabled,
+			password_reset_required, disabled_at is not null, created_at, updated_at
 					from users where id = $1`
 
 	var user models.User
@@ -267,10 +280,15 @@ func (m *PostgresDbRepo) GetUSerById(id int) (*models.User, error) {
 	row := m.DB.QueryRowContext(ctx, query, id)
 	err := row.Scan(
 		&user.ID,
-		&user.FirstName,
 		&user.Email,
-		&user.Password,
+		&user.FirstName,
 		&user.LastName,
+		&user.Password,
+		&user.EmailVerified,
+		&user.TOTPSecret,
+		&user.TOTPEnabled,
+		&user.PasswordResetRequired,
+		&user.Disabled,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -281,6 +299,181 @@ func (m *PostgresDbRepo) GetUSerById(id int) (*models.User, error) {
 	return &user, nil
 }
 
+// insert a new user holding roles, or the default viewer role when roles is nil
+func (m *PostgresDbRepo) InsertUser(user models.User, roles []string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var newUserID int
+
+	stmt := `insert into users (first_name, last_name, email, password, email_verified, password_reset_required, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8) returning id
+	`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		user.FirstName,
+		user.LastName,
+		user.Email,
+		user.Password,
+		user.EmailVerified,
+		user.PasswordResetRequired,
+		user.CreatedAt,
+		user.UpdatedAt,
+	).Scan(&newUserID)
+	if err != nil {
+		return 0, err
+	}
+
+	if roles == nil {
+		roles = []string{models.RoleViewer}
+	}
+	err = setUserRoles(ctx, tx, newUserID, roles)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return newUserID, nil
+}
+
+// mark the email verified, only if it is still the address the link was sent to
+func (m *PostgresDbRepo) VerifyUserEmail(id int, email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	stmt := `update users set email_verified = true, updated_at = $1 where id = $2 and email = $3`
+
+	result, err := m.DB.ExecContext(ctx, stmt, time.Now(), id, email)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (m *PostgresDbRepo) InsertPasswordReset(userID int, tokenHash string, expiry time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	stmt := `insert into password_resets (user_id, token_hash, expires_at, created_at) values ($1, $2, $3, $4)`
+
+	_, err := m.DB.ExecContext(ctx, stmt, userID, tokenHash, expiry, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// use up a reset token and set the new password, revoking every session
+// so refresh tokens issued before the reset stop working
+func (m *PostgresDbRepo) ResetPassword(tokenHash, passwordHash string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// claiming the token in the update makes it single use even with concurrent requests
+	var userID int
+	stmt := `update password_resets set used_at = $1
+			where token_hash = $2 and used_at is null and expires_at > $1
+			returning user_id`
+
+	err = tx.QueryRowContext(ctx, stmt, time.Now(), tokenHash).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt = `update users set password = $1, password_reset_required = false, updated_at = $2 where id = $3`
+	_, err = tx.ExecContext(ctx, stmt, passwordHash, time.Now(), userID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = revokeUserSessions(ctx, tx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	// any other link still sitting in the user's inbox should die with this one
+	stmt = `update password_resets set used_at = $1 where user_id = $2 and used_at is null`
+	_, err = tx.ExecContext(ctx, stmt, time.Now(), userID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+func (m *PostgresDbRepo) GetUserRoles(id int) ([]*models.Role, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	query := `select r.id, r.role, r.mfa_required, coalesce(p.permission, '') from users_roles ur
+			left join roles r on (ur.role_id = r.id)
+			left join roles_permissions rp on (rp.role_id = r.id)
+			left join permissions p on (rp.permission_id = p.id)
+			where ur.user_id = $1
+			order by r.id, p.permission
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// each role comes back once per permission so group them as we go
+	var roles []*models.Role
+	for rows.Next() {
+		var roleID int
+		var role, permission string
+		var mfaRequired bool
+		err := rows.Scan(&roleID, &role, &mfaRequired, &permission)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(roles) == 0 || roles[len(roles)-1].ID != roleID {
+			roles = append(roles, &models.Role{ID: roleID, Role: role, MFARequired: mfaRequired})
+		}
+
+		if permission != "" {
+			current := roles[len(roles)-1]
+			current.Permissions = append(current.Permissions, permission)
+		}
+	}
+
+	return roles, rows.Err()
+}
+
 func (m *PostgresDbRepo) AllGenres() ([]*models.Genre, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
 	defer cancel()
@@ -316,8 +509,8 @@ func (m *PostgresDbRepo) InsertMovie(movie models.Movie) (int, error) {
 
 	var newMovieID int
 
-	stmt := `insert into movies (id, title, description, release_date, runtime, mpaa_rating, created_at, updated_at, image)
-			values ($1,$2, $3,$4, $5, $6, $7, $8) returning id
+	stmt := `insert into movies (title, description, release_date, runtime, mpaa_rating, created_at, updated_at, image, created_by, updated_by)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) returning id
 	`
 
 	err := m.DB.QueryRowContext(ctx, stmt,
@@ -329,6 +522,8 @@ func (m *PostgresDbRepo) InsertMovie(movie models.Movie) (int, error) {
 		movie.CreatedAt,
 		movie.UpdatedAt,
 		movie.Image,
+		nullID(movie.CreatedBy),
+		nullID(movie.UpdatedBy),
 	).Scan(&newMovieID)
 
 	if err != nil {
@@ -342,11 +537,11 @@ func (m *PostgresDbRepo) UpdateMovie(movie models.Movie) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
 	defer cancel()
 
-	stmt := `update movies set title = $1, description = $2, release_date = $3, 
-				runtime = $4, mpaa_rating = $5, 
-				updated_at = $6, image = $7 where id = $8`
+	stmt := `update movies set title = $1, description = $2, release_date = $3,
+				runtime = $4, mpaa_rating = $5,
+				updated_at = $6, image = $7, updated_by = $8 where id = $9`
 
-	_, err := m.DB.ExecContext(ctx, stmt,
+	result, err := m.DB.ExecContext(ctx, stmt,
 		movie.Title,
 		movie.Description,
 		movie.ReleaseDate,
@@ -354,6 +549,7 @@ func (m *PostgresDbRepo) UpdateMovie(movie models.Movie) error {
 		movie.MPAARating,
 		movie.UpdatedAt,
 		movie.Image,
+		nullID(movie.UpdatedBy),
 		movie.ID,
 	)
 
@@ -361,7 +557,7 @@ func (m *PostgresDbRepo) UpdateMovie(movie models.Movie) error {
 		return err
 	}
 
-	return nil
+	return expectRow(result)
 }
 
 func (m *PostgresDbRepo) UpdateMovieGenre(id int, genreIDs []int) error {
@@ -369,7 +565,7 @@ func (m *PostgresDbRepo) UpdateMovieGenre(id int, genreIDs []int) error {
 	defer cancel()
 
 	// for this purpose first delete the movie genres id
-	stmt := `delete from movie_genres where movie_d = $1`
+	stmt := `delete from movies_genres where movie_id = $1`
 	_, err := m.DB.ExecContext(ctx, stmt, id)
 	if err != nil {
 		return err
@@ -377,7 +573,7 @@ func (m *PostgresDbRepo) UpdateMovieGenre(id int, genreIDs []int) error {
 
 	// range through the genre Ids and insert into movie genres
 	for _, n := range genreIDs {
-		stmt := `insert into movie_genres (movie_id, genre_id) values ($1, $2)`
+		stmt := `insert into movies_genres (movie_id, genre_id) values ($1, $2)`
 		_, err := m.DB.ExecContext(ctx, stmt, id, n)
 		if err != nil {
 			return err
@@ -392,10 +588,15 @@ func (m *PostgresDbRepo) DeleteMovie(id int) error {
 
 	stmt := `delete from movies where id = $1`
 
-	_, err := m.DB.ExecContext(ctx, stmt, id)
+	result, err := m.DB.ExecContext(ctx, stmt, id)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return expectRow(result)
+}
+
+// store a user id, or null when there isn't one
+func nullID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
 }
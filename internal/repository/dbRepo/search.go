@@ -0,0 +1,113 @@
+package dbrepo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/toluhikay/go-react/internal/models"
+)
+
+const headlineOptions = "StartSel=<mark>, StopSel=</mark>"
+
+// a sql expression for the html escaped text of expr. Titles and descriptions
+// are escaped before ts_headline marks them up, so the <mark> tags it adds are
+// the only markup in a highlight and it can be shown as html. The parser reads
+// the entities as entities rather than words, so matching is unaffected
+func escapeHTML(expr string) string {
+	return fmt.Sprintf(`replace(replace(replace(replace(replace(%s, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&quot;'), '''', '&#39;')`, expr)
+}
+
+// search the catalogue, best matches first, returning a page of results and
+// the total number of matches. Full text matches rank by ts_rank, and titles
+// that are only close to the query through trigram similarity still match
+// so typos find something
+func (m *PostgresDbRepo) SearchMovies(ctx context.Context, search models.MovieSearch) ([]*models.MovieSearchResult, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeOut)
+	defer cancel()
+
+	args := []interface{}{search.Query}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	// the trigram operators take the bare column so movies_title_trgm_idx is used
+	where := []string{"(m.search_vector @@ q.query or m.title % $1 or $1 <% m.title)"}
+	if len(search.Ratings) > 0 {
+		where = append(where, "m.mpaa_rating = any("+arg(search.Ratings)+")")
+	}
+	if len(search.Genres) > 0 {
+		where = append(where, "m.id in (select movie_id from movies_genres where genre_id = any("+arg(search.Genres)+"))")
+	}
+	clause := strings.Join(where, " and ")
+
+	query := fmt.Sprintf(`
+		with q as (select websearch_to_tsquery('english', $1) as query)
+		select
+			m.id, m.title, m.release_date, m.runtime,
+			m.mpaa_rating, m.description, coalesce(m.image, ''),
+			m.created_at, m.updated_at,
+			ts_rank(m.search_vector, q.query) + similarity(coalesce(m.title, ''), $1) as rank,
+			ts_headline('english', %[5]s, q.query, '%[1]s, HighlightAll=true'),
+			ts_headline('english', %[6]s, q.query, '%[1]s, MaxFragments=2, MinWords=5, MaxWords=20'),
+			count(*) over ()
+		from
+			movies m, q
+		where
+			%[2]s
+		order by
+			rank desc, m.id
+		limit %[3]s offset %[4]s
+	`, headlineOptions, clause, arg(search.Limit), arg(search.Offset),
+		escapeHTML("coalesce(m.title, '')"), escapeHTML("coalesce(m.description, '')"))
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	results := []*models.MovieSearchResult{}
+	total := 0
+	for rows.Next() {
+		var result models.MovieSearchResult
+		err := rows.Scan(
+			&result.ID,
+			&result.Title,
+			&result.ReleaseDate,
+			&result.RunTime,
+			&result.MPAARating,
+			&result.Description,
+			&result.Image,
+			&result.CreatedAt,
+			&result.UpdatedAt,
+			&result.Rank,
+			&result.TitleHighlight,
+			&result.Snippet,
+			&total,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		results = append(results, &result)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	// a page past the end has no rows to carry the total, so count separately
+	if len(results) == 0 && search.Offset > 0 {
+		query = fmt.Sprintf(`
+			with q as (select websearch_to_tsquery('english', $1) as query)
+			select count(*) from movies m, q where %s
+		`, clause)
+
+		err = m.DB.QueryRowContext(ctx, query, args[:len(args)-2]...).Scan(&total)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return results, total, nil
+}
@@ -0,0 +1,245 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/toluhikay/go-react/internal/models"
+)
+
+// anything we can run statements on, so helpers work inside and outside a transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// start a session together with the first refresh token issued for it
+func (m *PostgresDbRepo) InsertSession(session models.Session, token models.RefreshToken) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `insert into sessions (id, user_id, user_agent, ip_address, created_at, last_used_at)
+			values ($1, $2, $3, $4, $5, $6)`
+	_, err = tx.ExecContext(ctx, stmt,
+		session.ID,
+		session.UserID,
+		session.UserAgent,
+		session.IPAddress,
+		session.CreatedAt,
+		session.LastUsedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = insertRefreshToken(ctx, tx, token)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertRefreshToken(ctx context.Context, db execer, token models.RefreshToken) error {
+	stmt := `insert into refresh_tokens (id, session_id, user_id, expires_at, created_at) values ($1, $2, $3, $4, $5)`
+	_, err := db.ExecContext(ctx, stmt, token.ID, token.SessionID, token.UserID, token.ExpiresAt, token.CreatedAt)
+	return err
+}
+
+// get a refresh token by its jti, treating it as revoked when its session is
+func (m *PostgresDbRepo) GetRefreshToken(id string) (*models.RefreshToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	query := `select rt.id, rt.session_id, rt.user_id, rt.expires_at, rt.created_at, rt.used_at,
+				coalesce(rt.replaced_by, ''), coalesce(rt.revoked_at, s.revoked_at)
+			from refresh_tokens rt
+			join sessions s on (rt.session_id = s.id)
+			where rt.id = $1`
+
+	var token models.RefreshToken
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&token.ID,
+		&token.SessionID,
+		&token.UserID,
+		&token.ExpiresAt,
+		&token.CreatedAt,
+		&token.UsedAt,
+		&token.ReplacedBy,
+		&token.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// swap a refresh token for the next one in its session. Returns sql.ErrNoRows
+// when the old token was already used or revoked, which means it is being replayed
+func (m *PostgresDbRepo) RotateRefreshToken(oldID string, next models.RefreshToken) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the conditions in the where clause make sure only one caller can rotate a token
+	stmt := `update refresh_tokens set used_at = $1, replaced_by = $2
+			where id = $3 and used_at is null and revoked_at is null and expires_at > $1`
+	result, err := tx.ExecContext(ctx, stmt, next.CreatedAt, next.ID, oldID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	err = insertRefreshToken(ctx, tx, next)
+	if err != nil {
+		return err
+	}
+
+	stmt = `update sessions set last_used_at = $1 where id = $2`
+	_, err = tx.ExecContext(ctx, stmt, next.CreatedAt, next.SessionID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// list the sessions a user is still signed in with, most recently used first
+func (m *PostgresDbRepo) GetUserSessions(userID int) ([]*models.Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// a session is only live while it still holds an unused, unexpired refresh token
+	query := `select s.id, s.user_id, coalesce(s.user_agent, ''), coalesce(s.ip_address, ''), s.created_at, s.last_used_at
+			from sessions s
+			where s.user_id = $1 and s.revoked_at is null
+			and exists (
+				select 1 from refresh_tokens rt
+				where rt.session_id = s.id and rt.used_at is null and rt.revoked_at is null and rt.expires_at > $2
+			)
+			order by s.last_used_at desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*models.Session
+	for rows.Next() {
+		var session models.Session
+		err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.UserAgent,
+			&session.IPAddress,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// revoke one of a user's sessions, returning sql.ErrNoRows if they don't own it
+func (m *PostgresDbRepo) RevokeUserSession(userID int, id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	var owner int
+	err := m.DB.QueryRowContext(ctx, `select user_id from sessions where id = $1`, id).Scan(&owner)
+	if err != nil {
+		return err
+	}
+	if owner != userID {
+		return sql.ErrNoRows
+	}
+
+	return m.RevokeSession(id)
+}
+
+// revoke a session and every refresh token in it
+func (m *PostgresDbRepo) RevokeSession(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	now := time.Now()
+
+	stmt := `update sessions set revoked_at = $1 where id = $2 and revoked_at is null`
+	_, err := m.DB.ExecContext(ctx, stmt, now, id)
+	if err != nil {
+		return err
+	}
+
+	stmt = `update refresh_tokens set revoked_at = $1 where session_id = $2 and revoked_at is null`
+	_, err = m.DB.ExecContext(ctx, stmt, now, id)
+	return err
+}
+
+// revoke every session a user has, e.g. after their password changes
+func (m *PostgresDbRepo) RevokeUserSessions(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	return revokeUserSessions(ctx, m.DB, userID)
+}
+
+// whether an access token issued to the user can still be used. The user has
+// to exist and not be disabled, and the session the token came from, when it
+// has one, can't have been revoked
+func (m *PostgresDbRepo) AccessAllowed(userID int, sessionID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	query := `
+		select exists (
+			select 1 from users u
+			where u.id = $1 and u.disabled_at is null
+			and ($2 = '' or exists (
+				select 1 from sessions s
+				where s.id = $2 and s.user_id = u.id and s.revoked_at is null
+			))
+		)
+	`
+
+	var allowed bool
+	err := m.DB.QueryRowContext(ctx, query, userID, sessionID).Scan(&allowed)
+	return allowed, err
+}
+
+func revokeUserSessions(ctx context.Context, db execer, userID int) error {
+	now := time.Now()
+
+	stmt := `update sessions set revoked_at = $1 where user_id = $2 and revoked_at is null`
+	_, err := db.ExecContext(ctx, stmt, now, userID)
+	if err != nil {
+		return err
+	}
+
+	stmt = `update refresh_tokens set revoked_at = $1 where user_id = $2 and revoked_at is null`
+	_, err = db.ExecContext(ctx, stmt, now, userID)
+	return err
+}
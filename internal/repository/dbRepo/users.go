@@ -0,0 +1,317 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/toluhikay/go-react/internal/models"
+)
+
+// return one page of users ordered by id along with the total number of users
+func (m *PostgresDbRepo) AllUsers(page, pageSize int) ([]*models.User, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	query := `
+		select
+			u.id, u.first_name, u.last_name, u.email, u.email_verified,
+			u.totp_enabled, u.password_reset_required, u.disabled_at is not null,
+			u.created_at, u.updated_at,
+			coalesce(string_agg(r.role, ' ' order by r.id), ''),
+			count(*) over ()
+		from
+			users u
+			left join users_roles ur on (ur.user_id = u.id)
+			left join roles r on (r.id = ur.role_id)
+		group by
+			u.id
+		order by
+			u.id
+		limit $1 offset $2
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, pageSize, (page-1)*pageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := []*models.User{}
+	total := 0
+
+	for rows.Next() {
+		var user models.User
+		var roles string
+		err := rows.Scan(
+			&user.ID,
+			&user.FirstName,
+			&user.LastName,
+			&user.Email,
+			&user.EmailVerified,
+			&user.TOTPEnabled,
+			&user.PasswordResetRequired,
+			&user.Disabled,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+			&roles,
+			&total,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		user.Roles = splitScopes(roles)
+		users = append(users, &user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	// a page past the end has no rows to carry the total, so count separately
+	if len(users) == 0 {
+		err = m.DB.QueryRowContext(ctx, `select count(*) from users`).Scan(&total)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return users, total, nil
+}
+
+// update a user's name and email, and replace their roles unless roles is
+// nil. A changed email has to be verified again
+func (m *PostgresDbRepo) UpdateUser(user models.User, roles []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `update users set first_name = $1, last_name = $2,
+			email_verified = email_verified and email = $3, email = $3, updated_at = $4
+			where id = $5`
+
+	result, err := tx.ExecContext(ctx, stmt,
+		user.FirstName,
+		user.LastName,
+		user.Email,
+		time.Now(),
+		user.ID,
+	)
+	if err != nil {
+		return err
+	}
+	err = expectRow(result)
+	if err != nil {
+		return err
+	}
+
+	if roles != nil {
+		err = setUserRoles(ctx, tx, user.ID, roles)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// the names in roles that aren't roles, so they can be refused before
+// anything is written
+func (m *PostgresDbRepo) UnknownRoles(roles []string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `select role from roles where role = any($1)`, roles)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	known := make(map[string]bool)
+	for rows.Next() {
+		var role string
+		err = rows.Scan(&role)
+		if err != nil {
+			return nil, err
+		}
+		known[role] = true
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var unknown []string
+	for _, role := range roles {
+		if !known[role] {
+			unknown = append(unknown, role)
+		}
+	}
+	return unknown, nil
+}
+
+// replace the roles a user holds. Unknown role names are an error
+func setUserRoles(ctx context.Context, tx *sql.Tx, userID int, roles []string) error {
+	var roleIDs []int
+	for _, role := range roles {
+		var id int
+		err := tx.QueryRowContext(ctx, `select id from roles where role = $1`, role).Scan(&id)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("unknown role %q", role)
+		}
+		if err != nil {
+			return err
+		}
+		roleIDs = append(roleIDs, id)
+	}
+
+	_, err := tx.ExecContext(ctx, `delete from users_roles where user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range roleIDs {
+		_, err = tx.ExecContext(ctx, `insert into users_roles (user_id, role_id) values ($1, $2)`, userID, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// set a new password chosen by the user, signing them out everywhere and
+// killing any reset links still waiting in their inbox
+func (m *PostgresDbRepo) ChangePassword(userID int, passwordHash string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	stmt := `update users set password = $1, password_reset_required = false, updated_at = $2 where id = $3`
+	result, err := tx.ExecContext(ctx, stmt, passwordHash, now, userID)
+	if err != nil {
+		return err
+	}
+
+	err = expectRow(result)
+	if err != nil {
+		return err
+	}
+
+	err = revokeUserSessions(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
+	stmt = `update password_resets set used_at = $1 where user_id = $2 and used_at is null`
+	_, err = tx.ExecContext(ctx, stmt, now, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// disable or enable an account. Disabling signs the user out everywhere
+func (m *PostgresDbRepo) SetUserDisabled(userID int, disabled bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// keep the original time if the account is already disabled
+	stmt := `update users set disabled_at = coalesce(disabled_at, $1), updated_at = $1 where id = $2`
+	if !disabled {
+		stmt = `update users set disabled_at = null, updated_at = $1 where id = $2`
+	}
+
+	result, err := tx.ExecContext(ctx, stmt, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	err = expectRow(result)
+	if err != nil {
+		return err
+	}
+
+	if disabled {
+		err = revokeUserSessions(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// make the user pick a new password before they can log in again
+func (m *PostgresDbRepo) RequirePasswordReset(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `update users set password_reset_required = true, updated_at = $1 where id = $2`
+	result, err := tx.ExecContext(ctx, stmt, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	err = expectRow(result)
+	if err != nil {
+		return err
+	}
+
+	err = revokeUserSessions(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// delete a user. Sessions, refresh tokens and everything else hanging off
+// the user go with it through the on delete cascade foreign keys
+func (m *PostgresDbRepo) DeleteUser(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `delete from users where id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return expectRow(result)
+}
+
+// turn an update that touched nothing into sql.ErrNoRows
+func expectRow(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -1,7 +1,9 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"time"
 
 	"github.com/toluhikay/go-react/internal/models"
 )
@@ -9,12 +11,59 @@ import (
 type DatabaseRepo interface {
 	Connection() *sql.DB
 	AllMovies(genre ...int) ([]*models.Movie, error)
+	ListMovies(ctx context.Context, query models.MovieQuery) ([]*models.Movie, error)
+	SearchMovies(ctx context.Context, search models.MovieSearch) ([]*models.MovieSearchResult, int, error)
 	GetUserByEMail(email string) (*models.User, error)
 	GetUSerById(id int) (*models.User, error)
+	GetUserRoles(id int) ([]*models.Role, error)
+	InsertUser(user models.User, roles []string) (int, error)
+	VerifyUserEmail(id int, email string) error
+	InsertPasswordReset(userID int, tokenHash string, expiry time.Time) error
+	ResetPassword(tokenHash, passwordHash string) (int, error)
+
+	AllUsers(page, pageSize int) ([]*models.User, int, error)
+	UpdateUser(user models.User, roles []string) error
+	ChangePassword(userID int, passwordHash string) error
+	UnknownRoles(roles []string) ([]string, error)
+	SetUserDisabled(userID int, disabled bool) error
+	RequirePasswordReset(userID int) error
+	DeleteUser(userID int) error
+
+	SetUserTOTPSecret(userID int, secret string) error
+	EnableUserTOTP(userID int, step int64, recoveryCodeHashes []string) error
+	UseTOTPStep(userID int, step int64) error
+	UseRecoveryCode(userID int, codeHash string) error
+
+	GetLoginAttempts(key string) (*models.LoginAttempts, error)
+	AddLoginFailure(key string, now time.Time, window time.Duration) (*models.LoginAttempts, error)
+	LockLogin(key string, until time.Time) error
+	ResetLoginAttempts(key string) error
+
+	InsertAPIKey(key models.APIKey, keyHash string) (int, error)
+	GetUserAPIKeys(userID int) ([]*models.APIKey, error)
+	UseAPIKey(keyHash string) (*models.APIKey, error)
+	RevokeAPIKey(userID, id int) error
+
+	InsertAuditEntry(entry models.AuditEntry) error
+	GetAuditEntries(filter models.AuditFilter) ([]*models.AuditEntry, error)
+
+	GetUserByIdentity(issuer, subject string) (*models.User, error)
+	LinkIdentity(userID int, issuer, subject string) error
+
+	InsertSession(session models.Session, token models.RefreshToken) error
+	GetRefreshToken(id string) (*models.RefreshToken, error)
+	RotateRefreshToken(oldID string, next models.RefreshToken) error
+	GetUserSessions(userID int) ([]*models.Session, error)
+	RevokeUserSession(userID int, id string) error
+	RevokeSession(id string) error
+	RevokeUserSessions(userID int) error
+	AccessAllowed(userID int, sessionID string) (bool, error)
 
 	GetOneMovie(id int) (*models.Movie, error)
 	GetOneMovieForEdit(id int) (*models.Movie, []*models.Genre, error)
 	AllGenres() ([]*models.Genre, error)
+	GenresByMovieIDs(ctx context.Context, ids []int) (map[int][]*models.Genre, error)
+	MoviesByGenreIDs(ctx context.Context, ids []int, limit int) (map[int][]*models.Movie, error)
 	InsertMovie(movie models.Movie) (int, error)
 	UpdateMovieGenre(id int, genreIDs []int) error
 	UpdateMovie(movie models.Movie) error
@@ -0,0 +1,286 @@
+// Package suggest is an in-process prefix index for search box suggestions.
+//
+// Every word of an entry's text starts a key in a trie, so "god" finds
+// "The Godfather". Each trie node keeps its best entries ready, which makes a
+// lookup a walk down the prefix and a slice copy however many entries match.
+package suggest
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// something that can be suggested, such as a movie title
+type Entry struct {
+	Kind string `json:"kind"`
+	ID   int    `json:"id"`
+	Text string `json:"text"`
+	// how popular the entry is, higher scores are suggested first
+	Score float64 `json:"-"`
+}
+
+type entryKey struct {
+	kind string
+	id   int
+}
+
+type item struct {
+	Entry
+	keys []string
+}
+
+type node struct {
+	children map[rune]*node
+	// entries with a key ending at this node
+	terminal []*item
+	// the best entries in this subtree, at most Index.max of them
+	top []*item
+}
+
+// a prefix index keeping the best max suggestions for every prefix
+type Index struct {
+	mu    sync.RWMutex
+	max   int
+	root  *node
+	items map[entryKey]*item
+}
+
+// create an index that can return up to max suggestions per lookup
+func New(max int) *Index {
+	return &Index{
+		max:   max,
+		root:  &node{},
+		items: make(map[entryKey]*item),
+	}
+}
+
+// the most suggestions a lookup can return
+func (ix *Index) Max() int {
+	return ix.max
+}
+
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.items)
+}
+
+// add an entry, or update the text of one already in the index. An entry
+// already in the index keeps the score it has built up
+func (ix *Index) Put(e Entry) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	k := entryKey{e.Kind, e.ID}
+	if old, ok := ix.items[k]; ok {
+		e.Score = old.Score
+		ix.remove(old)
+	}
+
+	it := &item{Entry: e, keys: keys(e.Text)}
+	ix.items[k] = it
+
+	for _, key := range it.keys {
+		path := ix.path(key, true)
+		end := path[len(path)-1]
+		end.terminal = append(end.terminal, it)
+		ix.rebuild(path)
+	}
+}
+
+// remove an entry from the index
+func (ix *Index) Remove(kind string, id int) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if it, ok := ix.items[entryKey{kind, id}]; ok {
+		ix.remove(it)
+	}
+}
+
+// add to an entry's score, e.g. each time it is viewed
+func (ix *Index) Bump(kind string, id int, delta float64) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	it, ok := ix.items[entryKey{kind, id}]
+	if !ok {
+		return
+	}
+
+	it.Score += delta
+	for _, key := range it.keys {
+		ix.rebuild(ix.path(key, false))
+	}
+}
+
+// the best n entries with a word starting with prefix
+func (ix *Index) Suggest(prefix string, n int) []Entry {
+	results := []Entry{}
+
+	prefix = normalize(prefix)
+	if prefix == "" || n <= 0 {
+		return results
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	path := ix.path(prefix, false)
+	if len(path) != len([]rune(prefix))+1 {
+		return results
+	}
+
+	for _, it := range path[len(path)-1].top {
+		if len(results) == n {
+			break
+		}
+		results = append(results, it.Entry)
+	}
+
+	return results
+}
+
+func (ix *Index) remove(it *item) {
+	delete(ix.items, entryKey{it.Kind, it.ID})
+
+	for _, key := range it.keys {
+		path := ix.path(key, false)
+		end := path[len(path)-1]
+		for i, t := range end.terminal {
+			if t == it {
+				end.terminal = append(end.terminal[:i], end.terminal[i+1:]...)
+				break
+			}
+		}
+		ix.rebuild(path)
+		ix.prune(key, path)
+	}
+}
+
+// the nodes from the root down to key, stopping early if create is false and
+// the key isn't in the trie
+func (ix *Index) path(key string, create bool) []*node {
+	path := []*node{ix.root}
+	n := ix.root
+	for _, r := range key {
+		child, ok := n.children[r]
+		if !ok {
+			if !create {
+				return path
+			}
+			if n.children == nil {
+				n.children = make(map[rune]*node)
+			}
+			child = &node{}
+			n.children[r] = child
+		}
+		path = append(path, child)
+		n = child
+	}
+	return path
+}
+
+// recompute the best entries of every node on the path, deepest first, since
+// a node's best entries come from its own and its children's
+func (ix *Index) rebuild(path []*node) {
+	for i := len(path) - 1; i >= 0; i-- {
+		n := path[i]
+
+		seen := make(map[*item]bool)
+		var candidates []*item
+		add := func(items []*item) {
+			for _, it := range items {
+				if !seen[it] {
+					seen[it] = true
+					candidates = append(candidates, it)
+				}
+			}
+		}
+
+		add(n.terminal)
+		for _, child := range n.children {
+			add(child.top)
+		}
+
+		sort.Slice(candidates, func(a, b int) bool {
+			return better(candidates[a], candidates[b])
+		})
+		if len(candidates) > ix.max {
+			candidates = candidates[:ix.max]
+		}
+		n.top = candidates
+	}
+}
+
+// drop nodes left empty along a key's path
+func (ix *Index) prune(key string, path []*node) {
+	runes := []rune(key)
+	for i := len(path) - 1; i > 0; i-- {
+		n := path[i]
+		if len(n.terminal) > 0 || len(n.children) > 0 {
+			return
+		}
+		delete(path[i-1].children, runes[i-1])
+	}
+}
+
+// higher scores first, then shorter and alphabetical text so results are stable
+func better(a, b *item) bool {
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	if len(a.Text) != len(b.Text) {
+		return len(a.Text) < len(b.Text)
+	}
+	if a.Text != b.Text {
+		return a.Text < b.Text
+	}
+	if a.Kind != b.Kind {
+		return a.Kind < b.Kind
+	}
+	return a.ID < b.ID
+}
+
+// the keys an entry is found under, one starting at each word
+func keys(text string) []string {
+	words := strings.Fields(normalize(text))
+
+	var keys []string
+	seen := make(map[string]bool)
+	for i := range words {
+		key := strings.Join(words[i:], " ")
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// lower case letters and digits separated by single spaces. Apostrophes are
+// dropped and any other punctuation separates words. A trailing space is kept
+// so "the " only matches where the word "the" is complete
+func normalize(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			space = false
+			b.WriteRune(r)
+		case r == '\'' || r == '’':
+		default:
+			space = true
+		}
+	}
+	if space && b.Len() > 0 {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
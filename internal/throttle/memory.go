@@ -0,0 +1,93 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+
+	"github.com/toluhikay/go-react/internal/models"
+)
+
+const sweepInterval = time.Minute
+
+// keep attempts in memory, only suitable when running a single instance
+type MemoryStore struct {
+	mu        sync.Mutex
+	attempts  map[string]*models.LoginAttempts
+	window    time.Duration
+	lastSweep time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		attempts: make(map[string]*models.LoginAttempts),
+	}
+}
+
+func (s *MemoryStore) GetLoginAttempts(key string) (*models.LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts, ok := s.attempts[key]
+	if !ok {
+		return &models.LoginAttempts{Key: key}, nil
+	}
+
+	copied := *attempts
+	return &copied, nil
+}
+
+func (s *MemoryStore) AddLoginFailure(key string, now time.Time, window time.Duration) (*models.LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.window = window
+	s.sweep(now)
+
+	attempts, ok := s.attempts[key]
+	if !ok || now.Sub(attempts.LastFailureAt) > window {
+		attempts = &models.LoginAttempts{Key: key}
+		s.attempts[key] = attempts
+	}
+
+	attempts.Failures++
+	attempts.LastFailureAt = now
+
+	copied := *attempts
+	return &copied, nil
+}
+
+func (s *MemoryStore) LockLogin(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts, ok := s.attempts[key]
+	if !ok {
+		attempts = &models.LoginAttempts{Key: key}
+		s.attempts[key] = attempts
+	}
+	attempts.LockedUntil = until
+
+	return nil
+}
+
+func (s *MemoryStore) ResetLoginAttempts(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+// drop keys that are neither locked nor inside the window, so the map doesn't grow forever
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, attempts := range s.attempts {
+		if now.After(attempts.LockedUntil) && now.Sub(attempts.LastFailureAt) > s.window {
+			delete(s.attempts, key)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package throttle
+
+import (
+	"time"
+
+	"github.com/toluhikay/go-react/internal/models"
+)
+
+// where failed attempts are kept. The postgres repository implements this so
+// lockouts are shared between instances, MemoryStore works for a single one
+type Store interface {
+	GetLoginAttempts(key string) (*models.LoginAttempts, error)
+	// add a failure for key, starting the count over if the last failure is older than window
+	AddLoginFailure(key string, now time.Time, window time.Duration) (*models.LoginAttempts, error)
+	LockLogin(key string, until time.Time) error
+	ResetLoginAttempts(key string) error
+}
+
+// how many failures are allowed before locking, and for how long
+type Policy struct {
+	// failures allowed before the first lockout
+	Threshold int
+	// lockout after reaching the threshold, doubled with every failure after it
+	BaseLockout time.Duration
+	MaxLockout  time.Duration
+	// failures older than this are forgotten
+	Window time.Duration
+}
+
+// the lockout to apply after a given number of failures, zero while under the threshold
+func (p Policy) Lockout(failures int) time.Duration {
+	if failures < p.Threshold {
+		return 0
+	}
+
+	lockout := p.BaseLockout
+	for i := p.Threshold; i < failures; i++ {
+		lockout *= 2
+		if lockout >= p.MaxLockout {
+			return p.MaxLockout
+		}
+	}
+	return lockout
+}
+
+type Limiter struct {
+	Store  Store
+	Policy Policy
+}
+
+// how much longer key is locked out for, zero when it isn't
+func (l *Limiter) Locked(key string) (time.Duration, error) {
+	attempts, err := l.Store.GetLoginAttempts(key)
+	if err != nil {
+		return 0, err
+	}
+
+	remaining := time.Until(attempts.LockedUntil)
+	if remaining <= 0 {
+		return 0, nil
+	}
+	return remaining, nil
+}
+
+// record a failure for key and lock it when it goes over the threshold
+func (l *Limiter) Fail(key string) (time.Duration, error) {
+	now := time.Now()
+
+	attempts, err := l.Store.AddLoginFailure(key, now, l.Policy.Window)
+	if err != nil {
+		return 0, err
+	}
+
+	lockout := l.Policy.Lockout(attempts.Failures)
+	if lockout == 0 {
+		return 0, nil
+	}
+
+	err = l.Store.LockLogin(key, now.Add(lockout))
+	if err != nil {
+		return 0, err
+	}
+	return lockout, nil
+}
+
+// forget the failures for key, after a good login or when an admin unlocks it
+func (l *Limiter) Reset(key string) error {
+	return l.Store.ResetLoginAttempts(key)
+}
@@ -0,0 +1,96 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPolicyLockout(t *testing.T) {
+	p := Policy{Threshold: 5, BaseLockout: time.Minute, MaxLockout: time.Hour, Window: time.Hour}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{4, 0},
+		{5, time.Minute},
+		{6, 2 * time.Minute},
+		{7, 4 * time.Minute},
+		{10, 32 * time.Minute},
+		{11, time.Hour},
+		{100, time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := p.Lockout(tt.failures); got != tt.want {
+			t.Errorf("Lockout(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := &Limiter{
+		Store:  NewMemoryStore(),
+		Policy: Policy{Threshold: 2, BaseLockout: time.Minute, MaxLockout: time.Hour, Window: time.Hour},
+	}
+
+	if lockout, _ := l.Fail("a"); lockout != 0 {
+		t.Errorf("first failure locked for %s", lockout)
+	}
+	if lockout, _ := l.Fail("a"); lockout != time.Minute {
+		t.Errorf("failure at the threshold locked for %s, want 1m", lockout)
+	}
+	if wait, _ := l.Locked("a"); wait <= 0 || wait > time.Minute {
+		t.Errorf("Locked = %s, want up to 1m", wait)
+	}
+	if wait, _ := l.Locked("b"); wait != 0 {
+		t.Errorf("another key is locked for %s", wait)
+	}
+
+	if err := l.Reset("a"); err != nil {
+		t.Fatal(err)
+	}
+	if wait, _ := l.Locked("a"); wait != 0 {
+		t.Errorf("still locked for %s after a reset", wait)
+	}
+}
+
+func TestMemoryStoreWindow(t *testing.T) {
+	s := NewMemoryStore()
+	now := time.Now()
+
+	s.AddLoginFailure("a", now, time.Hour)
+	attempts, _ := s.AddLoginFailure("a", now.Add(30*time.Minute), time.Hour)
+	if attempts.Failures != 2 {
+		t.Errorf("failures inside the window = %d, want 2", attempts.Failures)
+	}
+
+	// the count starts over once the last failure is older than the window
+	attempts, _ = s.AddLoginFailure("a", now.Add(2*time.Hour), time.Hour)
+	if attempts.Failures != 1 {
+		t.Errorf("failures after the window = %d, want 1", attempts.Failures)
+	}
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	s := NewMemoryStore()
+	now := time.Now()
+
+	s.AddLoginFailure("stale", now, time.Hour)
+	s.AddLoginFailure("locked", now, time.Hour)
+	s.LockLogin("locked", now.Add(3*time.Hour))
+
+	// the next failure after the window sweeps what is neither recent nor locked
+	s.AddLoginFailure("fresh", now.Add(2*time.Hour), time.Hour)
+
+	if _, ok := s.attempts["stale"]; ok {
+		t.Error("stale attempts were not swept")
+	}
+	if _, ok := s.attempts["locked"]; !ok {
+		t.Error("a locked key was swept")
+	}
+	if _, ok := s.attempts["fresh"]; !ok {
+		t.Error("the new failure was swept")
+	}
+}
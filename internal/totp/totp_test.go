@@ -0,0 +1,73 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// the SHA1 secret from RFC 6238 appendix B, "12345678901234567890" in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// the RFC's test vectors, cut to the last six of their eight digits
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestCodeAt(t *testing.T) {
+	for _, v := range rfcVectors {
+		code, err := CodeAt(rfcSecret, Step(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != v.code {
+			t.Errorf("CodeAt(%d) = %s, want %s", v.unix, code, v.code)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	at := time.Unix(1111111109, 0)
+	step := Step(at)
+
+	tests := []struct {
+		name string
+		code string
+		at   time.Time
+		ok   bool
+	}{
+		{"current step", "081804", at, true},
+		{"spaces and padding", " 081 804 ", at, true},
+		{"a step behind", "081804", at.Add(Period * time.Second), true},
+		{"a step ahead", "081804", at.Add(-Period * time.Second), true},
+		{"outside the skew", "081804", at.Add(2 * Period * time.Second), false},
+		{"wrong code", "123456", at, false},
+		{"too short", "08180", at, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matched, ok := Verify(rfcSecret, tt.code, tt.at)
+			if ok != tt.ok {
+				t.Fatalf("Verify ok = %v, want %v", ok, tt.ok)
+			}
+			// the step the code was made for, whatever the clock says
+			if ok && matched != step {
+				t.Errorf("Verify matched step %d, want %d", matched, step)
+			}
+		})
+	}
+}
+
+func TestVerifyBadSecret(t *testing.T) {
+	if _, ok := Verify("not base32!", "081804", time.Unix(1111111109, 0)); ok {
+		t.Error("Verify accepted a code for an invalid secret")
+	}
+}
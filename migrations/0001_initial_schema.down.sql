@@ -0,0 +1,8 @@
+--
+-- drop everything the initial schema created, dependents first
+--
+
+DROP TABLE IF EXISTS public.movies_genres;
+DROP TABLE IF EXISTS public.movies;
+DROP TABLE IF EXISTS public.genres;
+DROP TABLE IF EXISTS public.users;
@@ -1,25 +1,9 @@
 --
--- PostgreSQL database dump
+-- the schema as the original sql/create_tables.sql dump created it. Everything
+-- added since is in later migrations, so a database created from that dump
+-- can be given a baseline of 2 and migrated up from there
 --
 
--- Dumped from database version 14.5 (Debian 14.5-1.pgdg110+1)
--- Dumped by pg_dump version 14.5 (Homebrew)
-
-SET statement_timeout = 0;
-SET lock_timeout = 0;
-SET idle_in_transaction_session_timeout = 0;
-SET client_encoding = 'UTF8';
-SET standard_conforming_strings = on;
-SELECT pg_catalog.set_config('search_path', '', false);
-SET check_function_bodies = false;
-SET xmloption = content;
-SET client_min_messages = warning;
-SET row_security = off;
-
-SET default_tablespace = '';
-
-SET default_table_access_method = heap;
-
 --
 -- Name: genres; Type: TABLE; Schema: public; Owner: -
 --
@@ -131,89 +115,6 @@ ALTER TABLE public.users ALTER COLUMN id ADD GENERATED ALWAYS AS IDENTITY (
 );
 
 
---
--- Data for Name: genres; Type: TABLE DATA; Schema: public; Owner: -
---
-
-COPY public.genres (id, genre, created_at, updated_at) FROM stdin;
-1	Comedy	2022-09-23 00:00:00	2022-09-23 00:00:00
-2	Sci-Fi	2022-09-23 00:00:00	2022-09-23 00:00:00
-3	Horror	2022-09-23 00:00:00	2022-09-23 00:00:00
-4	Romance	2022-09-23 00:00:00	2022-09-23 00:00:00
-5	Action	2022-09-23 00:00:00	2022-09-23 00:00:00
-6	Thriller	2022-09-23 00:00:00	2022-09-23 00:00:00
-7	Drama	2022-09-23 00:00:00	2022-09-23 00:00:00
-8	Mystery	2022-09-23 00:00:00	2022-09-23 00:00:00
-9	Crime	2022-09-23 00:00:00	2022-09-23 00:00:00
-10	Animation	2022-09-23 00:00:00	2022-09-23 00:00:00
-11	Adventure	2022-09-23 00:00:00	2022-09-23 00:00:00
-12	Fantasy	2022-09-23 00:00:00	2022-09-23 00:00:00
-13	Superhero	2022-09-23 00:00:00	2022-09-23 00:00:00
-\.
-
-
---
--- Data for Name: movies; Type: TABLE DATA; Schema: public; Owner: -
---
-
-COPY public.movies (id, title, release_date, runtime, mpaa_rating, description, image, created_at, updated_at) FROM stdin;
-1	Highlander	1986-03-07	116	R	He fought his first battle on the Scottish Highlands in 1536. He will fight his greatest battle on the streets of New York City in 1986. His name is Connor MacLeod. He is immortal.	/8Z8dptJEypuLoOQro1WugD855YE.jpg	2022-09-23 00:00:00	2022-09-23 00:00:00
-2	Raiders of the Lost Ark	1981-06-12	115	PG-13	Archaeology professor Indiana Jones ventures to seize a biblical artefact known as the Ark of the Covenant. While doing so, he puts up a fight against Renee and a troop of Nazis.	/ceG9VzoRAVGwivFU403Wc3AHRys.jpg	2022-09-23 00:00:00	2022-09-23 00:00:00
-3	The Godfather	1972-03-24	175	18A	The aging patriarch of an organized crime dynasty in postwar New York City transfers control of his clandestine empire to his reluctant youngest son.	/3bhkrj58Vtu7enYsRolD1fZdja1.jpg	2022-09-23 00:00:00	2022-09-23 00:00:00
-\.
-
-
---
--- Data for Name: movies_genres; Type: TABLE DATA; Schema: public; Owner: -
---
-
-COPY public.movies_genres (id, movie_id, genre_id) FROM stdin;
-1	1	5
-2	1	12
-3	2	5
-4	2	11
-5	3	9
-6	3	7
-\.
-
-
---
--- Data for Name: users; Type: TABLE DATA; Schema: public; Owner: -
---
-
-COPY public.users (id, first_name, last_name, email, password, created_at, updated_at) FROM stdin;
-1	Admin	User	admin@example.com	$2a$14$wVsaPvJnJJsomWArouWCtusem6S/.Gauq/GjOIEHpyh2DAMmso1wy	2022-09-23 00:00:00	2022-09-23 00:00:00
-\.
-
-
---
--- Name: genres_id_seq; Type: SEQUENCE SET; Schema: public; Owner: -
---
-
-SELECT pg_catalog.setval('public.genres_id_seq', 13, true);
-
-
---
--- Name: movies_genres_id_seq; Type: SEQUENCE SET; Schema: public; Owner: -
---
-
-SELECT pg_catalog.setval('public.movies_genres_id_seq', 6, true);
-
-
---
--- Name: movies_id_seq; Type: SEQUENCE SET; Schema: public; Owner: -
---
-
-SELECT pg_catalog.setval('public.movies_id_seq', 3, true);
-
-
---
--- Name: users_id_seq; Type: SEQUENCE SET; Schema: public; Owner: -
---
-
-SELECT pg_catalog.setval('public.users_id_seq', 1, true);
-
-
 --
 -- Name: genres genres_pkey; Type: CONSTRAINT; Schema: public; Owner: -
 --
@@ -260,8 +161,3 @@ ALTER TABLE ONLY public.movies_genres
 
 ALTER TABLE ONLY public.movies_genres
     ADD CONSTRAINT movies_genres_movie_id_fkey FOREIGN KEY (movie_id) REFERENCES public.movies(id) ON UPDATE CASCADE ON DELETE CASCADE;
-
-
---
--- PostgreSQL database dump complete
---
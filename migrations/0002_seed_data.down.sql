@@ -0,0 +1,9 @@
+--
+-- remove the seed rows, anything added since is left alone. Rows linking to
+-- them go with them through their foreign keys
+--
+
+DELETE FROM public.movies_genres WHERE id BETWEEN 1 AND 6;
+DELETE FROM public.movies WHERE id BETWEEN 1 AND 3;
+DELETE FROM public.genres WHERE id BETWEEN 1 AND 13;
+DELETE FROM public.users WHERE id = 1;
@@ -0,0 +1,41 @@
+--
+-- drop everything added to the original schema, dependents first
+--
+
+DROP INDEX IF EXISTS public.movies_title_trgm_idx;
+DROP INDEX IF EXISTS public.movies_search_vector_idx;
+DROP INDEX IF EXISTS public.movies_genres_genre_id_idx;
+DROP INDEX IF EXISTS public.movies_created_at_idx;
+DROP INDEX IF EXISTS public.movies_runtime_idx;
+DROP INDEX IF EXISTS public.movies_release_date_idx;
+DROP INDEX IF EXISTS public.movies_title_idx;
+
+DROP TABLE IF EXISTS public.audit_log;
+DROP FUNCTION IF EXISTS public.audit_log_append_only();
+DROP TABLE IF EXISTS public.user_identities;
+DROP TABLE IF EXISTS public.api_keys;
+DROP TABLE IF EXISTS public.login_attempts;
+DROP TABLE IF EXISTS public.mfa_recovery_codes;
+DROP TABLE IF EXISTS public.refresh_tokens;
+DROP TABLE IF EXISTS public.sessions;
+DROP TABLE IF EXISTS public.password_resets;
+DROP TABLE IF EXISTS public.users_roles;
+DROP TABLE IF EXISTS public.roles_permissions;
+DROP TABLE IF EXISTS public.permissions;
+DROP TABLE IF EXISTS public.roles;
+
+ALTER TABLE public.movies
+    DROP COLUMN IF EXISTS search_vector,
+    DROP COLUMN IF EXISTS updated_by,
+    DROP COLUMN IF EXISTS created_by;
+
+ALTER TABLE public.users
+    DROP CONSTRAINT IF EXISTS users_email_key,
+    DROP COLUMN IF EXISTS disabled_at,
+    DROP COLUMN IF EXISTS password_reset_required,
+    DROP COLUMN IF EXISTS totp_last_step,
+    DROP COLUMN IF EXISTS totp_enabled,
+    DROP COLUMN IF EXISTS totp_secret,
+    DROP COLUMN IF EXISTS email_verified;
+
+DROP EXTENSION IF EXISTS pg_trgm;
@@ -0,0 +1,9 @@
+--
+-- remove the seeded roles and permissions, anything added since is left
+-- alone. Rows linking to them go with them through their foreign keys
+--
+
+DELETE FROM public.users_roles WHERE id = 1;
+DELETE FROM public.roles_permissions WHERE id BETWEEN 1 AND 10;
+DELETE FROM public.permissions WHERE id BETWEEN 1 AND 7;
+DELETE FROM public.roles WHERE id BETWEEN 1 AND 3;
@@ -0,0 +1,40 @@
+--
+-- the roles and permissions the app expects, with the seeded admin user made
+-- an admin. The ids are kept so the links line up, and the sequences carry on
+-- after them
+--
+
+INSERT INTO public.roles (id, role, mfa_required, created_at, updated_at) OVERRIDING SYSTEM VALUE VALUES
+    (1, 'viewer', false, '2022-09-23 00:00:00', '2022-09-23 00:00:00'),
+    (2, 'editor', false, '2022-09-23 00:00:00', '2022-09-23 00:00:00'),
+    (3, 'admin', true, '2022-09-23 00:00:00', '2022-09-23 00:00:00');
+
+INSERT INTO public.permissions (id, permission, created_at, updated_at) OVERRIDING SYSTEM VALUE VALUES
+    (1, 'movies:read', '2022-09-23 00:00:00', '2022-09-23 00:00:00'),
+    (2, 'movies:write', '2022-09-23 00:00:00', '2022-09-23 00:00:00'),
+    (3, 'movies:delete', '2022-09-23 00:00:00', '2022-09-23 00:00:00'),
+    (4, 'users:manage', '2022-09-23 00:00:00', '2022-09-23 00:00:00'),
+    (5, 'users:impersonate', '2022-09-23 00:00:00', '2022-09-23 00:00:00'),
+    (6, 'movies:write_any', '2022-09-23 00:00:00', '2022-09-23 00:00:00'),
+    (7, 'audit:read', '2022-09-23 00:00:00', '2022-09-23 00:00:00');
+
+INSERT INTO public.roles_permissions (id, role_id, permission_id) OVERRIDING SYSTEM VALUE VALUES
+    (1, 1, 1),
+    (2, 2, 1),
+    (3, 2, 2),
+    (4, 3, 1),
+    (5, 3, 2),
+    (6, 3, 3),
+    (7, 3, 4),
+    (8, 3, 5),
+    (9, 3, 6),
+    (10, 3, 7);
+
+-- the seeded admin user, when it is still there
+INSERT INTO public.users_roles (id, user_id, role_id) OVERRIDING SYSTEM VALUE
+    SELECT 1, id, 3 FROM public.users WHERE id = 1;
+
+SELECT pg_catalog.setval('public.roles_id_seq', 3, true);
+SELECT pg_catalog.setval('public.permissions_id_seq', 7, true);
+SELECT pg_catalog.setval('public.roles_permissions_id_seq', 10, true);
+SELECT pg_catalog.setval('public.users_roles_id_seq', 1, true);
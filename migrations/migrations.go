@@ -0,0 +1,9 @@
+// Package migrations holds the database schema as numbered up and down
+// migrations, embedded so the server can apply them itself. Files are named
+// <version>_<name>.up.sql and <version>_<name>.down.sql.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS